@@ -0,0 +1,255 @@
+// Package bloom 可自动扩容的布隆过滤器（Scalable Bloom Filter），
+// 当前过滤器达到容量后追加一个容量更大、误判率更低的过滤器，使整体误判率不超过设定值
+package bloom
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"math"
+)
+
+const (
+	// growth 每个新过滤器的容量是上一个的倍数
+	growth = 2
+	// tightening 每个新过滤器的误判率是上一个的倍数
+	tightening = 0.8
+	magic      = "XSBF"
+	// maxFilters 容量每次翻倍，过滤器数量不会超过64个
+	maxFilters = 64
+	// readChunk 读取位数组时每次读取的uint64数量
+	readChunk = 1 << 16
+)
+
+var ErrInvalidData = errors.New("bloom: invalid data")
+
+// filter 固定容量的布隆过滤器
+type filter struct {
+	bits     []uint64
+	m        uint64
+	k        uint64
+	capacity uint64
+	count    uint64
+}
+
+func newFilter(capacity uint64, errorRate float64) *filter {
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2)))
+	m = max(m, 64)
+	k := uint64(math.Ceil(-math.Log2(errorRate)))
+	k = max(k, 1)
+	return &filter{
+		bits:     make([]uint64, (m+63)/64),
+		m:        m,
+		k:        k,
+		capacity: capacity,
+	}
+}
+
+func (f *filter) test(h1, h2 uint64) bool {
+	for i := uint64(0); i < f.k; i++ {
+		idx := (h1 + i*h2) % f.m
+		if f.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *filter) add(h1, h2 uint64) {
+	for i := uint64(0); i < f.k; i++ {
+		idx := (h1 + i*h2) % f.m
+		f.bits[idx/64] |= 1 << (idx % 64)
+	}
+	f.count++
+}
+
+// ScalableFilter 可自动扩容的布隆过滤器，非并发安全
+type ScalableFilter struct {
+	filters         []*filter
+	initialCapacity uint64
+	errorRate       float64
+}
+
+// New 新建布隆过滤器，initialCapacity为第一个过滤器的容量，errorRate为整体误判率
+func New(initialCapacity uint64, errorRate float64) *ScalableFilter {
+	if initialCapacity == 0 {
+		initialCapacity = 1
+	}
+	if errorRate <= 0 || errorRate >= 1 {
+		errorRate = 0.001
+	}
+	return &ScalableFilter{initialCapacity: initialCapacity, errorRate: errorRate}
+}
+
+// hash 使用双重哈希计算各个位置
+func hash(key []byte) (uint64, uint64) {
+	h := fnv.New128a()
+	_, _ = h.Write(key)
+	sum := h.Sum(nil)
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:]) | 1
+}
+
+// Test 判断key是否可能已存在
+func (s *ScalableFilter) Test(key []byte) bool {
+	h1, h2 := hash(key)
+	for _, f := range s.filters {
+		if f.test(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestAndAdd 判断key是否可能已存在，不存在时将其加入过滤器
+func (s *ScalableFilter) TestAndAdd(key []byte) bool {
+	h1, h2 := hash(key)
+	for _, f := range s.filters {
+		if f.test(h1, h2) {
+			return true
+		}
+	}
+	s.current().add(h1, h2)
+	return false
+}
+
+// current 当前写入的过滤器，已满时追加新的过滤器
+func (s *ScalableFilter) current() *filter {
+	n := len(s.filters)
+	if n > 0 && s.filters[n-1].count < s.filters[n-1].capacity {
+		return s.filters[n-1]
+	}
+	capacity := s.initialCapacity
+	errorRate := s.errorRate * (1 - tightening)
+	for i := 0; i < n; i++ {
+		capacity *= growth
+		errorRate *= tightening
+	}
+	f := newFilter(capacity, errorRate)
+	s.filters = append(s.filters, f)
+	return f
+}
+
+// Count 已加入的key数量
+func (s *ScalableFilter) Count() uint64 {
+	var count uint64
+	for _, f := range s.filters {
+		count += f.count
+	}
+	return count
+}
+
+// MemoryUsage 位数组占用的字节数
+func (s *ScalableFilter) MemoryUsage() int {
+	size := 0
+	for _, f := range s.filters {
+		size += len(f.bits) * 8
+	}
+	return size
+}
+
+// WriteTo 将过滤器序列化写入w
+func (s *ScalableFilter) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+	header := []any{
+		[]byte(magic),
+		s.initialCapacity,
+		math.Float64bits(s.errorRate),
+		uint64(len(s.filters)),
+	}
+	for _, v := range header {
+		if err := binary.Write(cw, binary.BigEndian, v); err != nil {
+			return cw.n, err
+		}
+	}
+	for _, f := range s.filters {
+		for _, v := range []any{f.m, f.k, f.capacity, f.count, f.bits} {
+			if err := binary.Write(cw, binary.BigEndian, v); err != nil {
+				return cw.n, err
+			}
+		}
+	}
+	return cw.n, bw.Flush()
+}
+
+// ReadFrom 从r读取WriteTo序列化的过滤器，覆盖当前内容
+func (s *ScalableFilter) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countReader{r: bufio.NewReader(r)}
+	head := make([]byte, len(magic))
+	if err := binary.Read(cr, binary.BigEndian, head); err != nil {
+		return cr.n, err
+	}
+	if string(head) != magic {
+		return cr.n, ErrInvalidData
+	}
+	var initialCapacity, errorRate, n uint64
+	for _, v := range []any{&initialCapacity, &errorRate, &n} {
+		if err := binary.Read(cr, binary.BigEndian, v); err != nil {
+			return cr.n, err
+		}
+	}
+	if rate := math.Float64frombits(errorRate); initialCapacity == 0 || !(rate > 0 && rate < 1) || n > maxFilters {
+		return cr.n, ErrInvalidData
+	}
+
+	filters := make([]*filter, 0, n)
+	for i := uint64(0); i < n; i++ {
+		f := &filter{}
+		for _, v := range []any{&f.m, &f.k, &f.capacity, &f.count} {
+			if err := binary.Read(cr, binary.BigEndian, v); err != nil {
+				return cr.n, err
+			}
+		}
+		// 第i个过滤器的容量由初始容量决定，m不小于64
+		if f.capacity != initialCapacity<<i || f.count > f.capacity || f.m < 64 || f.k == 0 || f.k > f.m {
+			return cr.n, ErrInvalidData
+		}
+		bits, err := readBits(cr, (f.m+63)/64)
+		if err != nil {
+			return cr.n, err
+		}
+		f.bits = bits
+		filters = append(filters, f)
+	}
+	s.initialCapacity = initialCapacity
+	s.errorRate = math.Float64frombits(errorRate)
+	s.filters = filters
+	return cr.n, nil
+}
+
+// readBits 分段读取位数组，数据损坏或不完整时在读取到相应长度的数据前返回错误，避免按头部中的长度一次性分配内存
+func readBits(r io.Reader, words uint64) ([]uint64, error) {
+	bits := make([]uint64, 0, min(words, readChunk))
+	for uint64(len(bits)) < words {
+		chunk := make([]uint64, min(words-uint64(len(bits)), readChunk))
+		if err := binary.Read(r, binary.BigEndian, chunk); err != nil {
+			return nil, err
+		}
+		bits = append(bits, chunk...)
+	}
+	return bits, nil
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
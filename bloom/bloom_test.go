@@ -0,0 +1,119 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestScalableFilter(t *testing.T) {
+	tests := []struct {
+		name            string
+		initialCapacity uint64
+		errorRate       float64
+		count           int
+	}{
+		{"Single filter", 10000, 0.01, 5000},
+		{"Scaled filters", 100, 0.01, 20000},
+		{"Low error rate", 1000, 0.0001, 10000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := New(tt.initialCapacity, tt.errorRate)
+			for i := 0; i < tt.count; i++ {
+				key := []byte(fmt.Sprintf("key-%d", i))
+				// 误判时TestAndAdd不会加入key，之后的Test仍然返回true
+				f.TestAndAdd(key)
+			}
+			for i := 0; i < tt.count; i++ {
+				if !f.Test([]byte(fmt.Sprintf("key-%d", i))) {
+					t.Fatalf("Test(key-%d) = false, expected true", i)
+				}
+			}
+
+			falsePositives := 0
+			for i := 0; i < tt.count; i++ {
+				if f.Test([]byte(fmt.Sprintf("other-%d", i))) {
+					falsePositives++
+				}
+			}
+			if rate := float64(falsePositives) / float64(tt.count); rate > tt.errorRate*2 {
+				t.Errorf("false positive rate = %v, expected <= %v", rate, tt.errorRate)
+			}
+		})
+	}
+}
+
+func TestWriteToReadFrom(t *testing.T) {
+	f := New(100, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.TestAndAdd([]byte(fmt.Sprintf("key-%d", i)))
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	g := New(1, 0.5)
+	if _, err := g.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom() error = %v", err)
+	}
+	if g.Count() != f.Count() || g.MemoryUsage() != f.MemoryUsage() {
+		t.Errorf("Count() = %d, MemoryUsage() = %d, expected %d, %d", g.Count(), g.MemoryUsage(), f.Count(), f.MemoryUsage())
+	}
+	for i := 0; i < 1000; i++ {
+		if !g.Test([]byte(fmt.Sprintf("key-%d", i))) {
+			t.Fatalf("Test(key-%d) = false after ReadFrom, expected true", i)
+		}
+	}
+
+	if _, err := g.ReadFrom(bytes.NewReader([]byte("invalid data"))); err == nil {
+		t.Errorf("ReadFrom(invalid data) error = nil, expected error")
+	}
+}
+
+func TestReadFromInvalid(t *testing.T) {
+	f := New(100, 0.01)
+	for i := 0; i < 300; i++ {
+		f.TestAndAdd([]byte(fmt.Sprintf("key-%d", i)))
+	}
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	data := buf.Bytes()
+
+	// 各字段的偏移：magic(4)、initialCapacity、errorRate、过滤器数量，之后为第一个过滤器的m、k、capacity、count
+	tests := []struct {
+		name     string
+		offset   int
+		value    uint64
+		truncate int
+	}{
+		{"Zero initial capacity", 4, 0, 0},
+		{"Error rate out of range", 12, math.Float64bits(1.5), 0},
+		{"Too many filters", 20, 1 << 40, 0},
+		{"Huge bit array", 28, 1 << 60, 0},
+		{"Zero hash functions", 36, 0, 0},
+		{"Capacity mismatch", 44, 12345, 0},
+		{"Count exceeds capacity", 52, 1 << 20, 0},
+		{"Truncated bits", 0, 0, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corrupted := bytes.Clone(data)
+			if tt.offset > 0 {
+				binary.BigEndian.PutUint64(corrupted[tt.offset:], tt.value)
+			}
+			corrupted = corrupted[:len(corrupted)-tt.truncate]
+			g := New(1, 0.5)
+			if _, err := g.ReadFrom(bytes.NewReader(corrupted)); err == nil {
+				t.Errorf("ReadFrom() error = nil, expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package xspider
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// AcceptEncoding HttpCompressionDownloaderMiddleware支持的压缩格式
+const AcceptEncoding = "gzip, deflate, br, zstd"
+
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// parseContentEncoding 解析Content-Encoding，返回按压缩顺序排列的编码列表
+func parseContentEncoding(values []string) []string {
+	var encodings []string
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			v = strings.ToLower(strings.TrimSpace(v))
+			if v == "" || v == "identity" {
+				continue
+			}
+			encodings = append(encodings, v)
+		}
+	}
+	return encodings
+}
+
+// newDecompressReader 返回按encoding解压r的Reader
+func newDecompressReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// 部分服务器返回不带zlib头的原始deflate数据
+		br := bufio.NewReader(r)
+		header, err := br.Peek(2)
+		if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case "br":
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case "zstd":
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, errUnsupportedEncoding
+	}
+}
+
+// decompress 按encoding解压body，解压后的大小超过maxSize时返回*DownloadSizeError，maxSize<=0时不限制
+func decompress(encoding string, body []byte, maxSize int) ([]byte, error) {
+	r, err := newDecompressReader(encoding, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(newSizeLimitReader(r, "", maxSize, 0, nil))
+}
+
+// decompressFile 按encoding解压文件并替换原文件，返回解压后的大小
+func decompressFile(encoding string, path string, maxSize int) (int64, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := decompressTo(encoding, src, path+".decoded", maxSize)
+	// 先关闭原文件再替换，兼容不允许覆盖已打开文件的系统
+	_ = src.Close()
+	if err != nil {
+		_ = os.Remove(path + ".decoded")
+		return 0, err
+	}
+	return n, os.Rename(path+".decoded", path)
+}
+
+// decompressTo 将src解压后写入文件dst
+func decompressTo(encoding string, src io.Reader, dst string, maxSize int) (int64, error) {
+	r, err := newDecompressReader(encoding, src)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, newSizeLimitReader(r, "", maxSize, 0, nil))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
@@ -0,0 +1,134 @@
+package xspider
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// persistentCookieJar 在cookiejar.Jar的基础上记录写入的Cookie，以便保存到磁盘并在下次运行时恢复
+type persistentCookieJar struct {
+	jar     *cookiejar.Jar
+	entries map[string]cookieEntry
+	mu      sync.Mutex
+}
+
+// cookieEntry Cookie及其来源Url，恢复时按来源Url重新写入Jar
+type cookieEntry struct {
+	Url    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+func newPersistentCookieJar() *persistentCookieJar {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return &persistentCookieJar{
+		jar:     jar,
+		entries: make(map[string]cookieEntry),
+	}
+}
+
+func cookieEntryKey(u *url.URL, cookie *http.Cookie) string {
+	domain := strings.ToLower(strings.TrimPrefix(cookie.Domain, "."))
+	if domain == "" {
+		domain = u.Hostname()
+	}
+	return domain + ";" + cookie.Path + ";" + cookie.Name
+}
+
+func (j *persistentCookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	for _, c := range cookies {
+		key := cookieEntryKey(u, c)
+		if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(now)) {
+			delete(j.entries, key)
+			continue
+		}
+		// MaxAge为相对时间，保存前转换为绝对的过期时间
+		cookie := *c
+		if cookie.MaxAge > 0 {
+			cookie.Expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+			cookie.MaxAge = 0
+		}
+		j.entries[key] = cookieEntry{Url: u.String(), Cookie: &cookie}
+	}
+}
+
+func (j *persistentCookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// Entries 返回所有未过期的Cookie记录
+func (j *persistentCookieJar) Entries() []cookieEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	res := make([]cookieEntry, 0, len(j.entries))
+	for _, e := range j.entries {
+		if !e.Cookie.Expires.IsZero() && e.Cookie.Expires.Before(now) {
+			continue
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// Load 将保存的Cookie记录重新写入Jar
+func (j *persistentCookieJar) Load(entries []cookieEntry) {
+	for _, e := range entries {
+		u, err := url.Parse(e.Url)
+		if err != nil || e.Cookie == nil {
+			continue
+		}
+		j.SetCookies(u, []*http.Cookie{e.Cookie})
+	}
+}
+
+// loadCookieJars 从文件中读取所有会话的Cookie，文件不存在时返回空结果
+func loadCookieJars(path string) (map[string]*persistentCookieJar, error) {
+	jars := make(map[string]*persistentCookieJar)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return jars, nil
+		}
+		return nil, err
+	}
+	var sessions map[string][]cookieEntry
+	if err = json.Unmarshal(data, &sessions); err != nil {
+		return nil, err
+	}
+	for name, entries := range sessions {
+		jar := newPersistentCookieJar()
+		jar.Load(entries)
+		jars[name] = jar
+	}
+	return jars, nil
+}
+
+// saveCookieJars 将所有会话的Cookie写入文件
+func saveCookieJars(path string, jars map[string]*persistentCookieJar) error {
+	sessions := make(map[string][]cookieEntry, len(jars))
+	for name, jar := range jars {
+		sessions[name] = jar.Entries()
+	}
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,128 @@
+package xspider
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// parseBindAddress 解析本地绑定地址，支持ip和ip:port两种格式
+func parseBindAddress(bindAddress string) (*net.TCPAddr, error) {
+	host, port := bindAddress, "0"
+	if h, p, err := net.SplitHostPort(bindAddress); err == nil {
+		host, port = h, p
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid bind address: %s", bindAddress)
+	}
+	addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		return nil, err
+	}
+	return addr, nil
+}
+
+// overrideAddr 按DNS_OVERRIDES替换addr中的主机，优先匹配host:port，其次匹配host
+func (d *DownloaderImpl) overrideAddr(addr string) string {
+	if len(d.dnsOverrides) == 0 {
+		return addr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	host = strings.ToLower(host)
+	if ip, ok := d.dnsOverrides[net.JoinHostPort(host, port)]; ok {
+		return net.JoinHostPort(ip, port)
+	}
+	if ip, ok := d.dnsOverrides[host]; ok {
+		return net.JoinHostPort(ip, port)
+	}
+	return addr
+}
+
+// resolveAddr 在本地解析addr中的主机，用于socks5代理
+func (d *DownloaderImpl) resolveAddr(ctx context.Context, addr string) (string, error) {
+	addr = d.overrideAddr(addr)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no such host: %s", host)
+	}
+	return net.JoinHostPort(ips[0].IP.String(), port), nil
+}
+
+// dialer 根据key创建Transport使用的代理函数和拨号函数，
+// HTTP代理由Transport处理，socks5代理在本地解析域名，socks5h代理由代理服务器解析域名
+func (d *DownloaderImpl) dialer(key transportKey) (func(*http.Request) (*url.URL, error), dialFunc, error) {
+	netDialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if key.bindAddress != "" {
+		addr, err := parseBindAddress(key.bindAddress)
+		if err != nil {
+			return nil, nil, err
+		}
+		netDialer.LocalAddr = addr
+	}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return netDialer.DialContext(ctx, network, d.overrideAddr(addr))
+	}
+
+	// 环境变量中的代理由HttpProxyDownloaderMiddleware处理，未设置代理时直接连接
+	if key.proxy == "" {
+		return nil, dial, nil
+	}
+	proxyUrl, err := url.Parse(key.proxy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch proxyUrl.Scheme {
+	case "http", "https":
+		return http.ProxyURL(proxyUrl), dial, nil
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if proxyUrl.User != nil {
+			password, _ := proxyUrl.User.Password()
+			auth = &proxy.Auth{User: proxyUrl.User.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", d.overrideAddr(proxyUrl.Host), auth, netDialer)
+		if err != nil {
+			return nil, nil, err
+		}
+		socksDialer := socks.(proxy.ContextDialer)
+		localResolve := proxyUrl.Scheme == "socks5"
+		return nil, func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if !localResolve {
+				return socksDialer.DialContext(ctx, network, d.overrideAddr(addr))
+			}
+			resolved, err := d.resolveAddr(ctx, addr)
+			if err != nil {
+				return nil, err
+			}
+			return socksDialer.DialContext(ctx, network, resolved)
+		}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported proxy scheme: %s", proxyUrl.Scheme)
+	}
+}
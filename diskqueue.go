@@ -0,0 +1,356 @@
+package xspider
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xue0228/xspider/container"
+)
+
+func init() {
+	RegisterSpiderModuler(&FIFODiskPriorityQueue{})
+	RegisterSpiderModuler(&LIFODiskPriorityQueue{})
+}
+
+// diskQueue 保存在单个文件中的队列，每条记录的格式为 长度|数据|长度，
+// 记录前后都保存长度以便从文件头（FIFO）或文件尾（LIFO）读取
+type diskQueue struct {
+	f    *os.File
+	path string
+	lifo bool
+	head int64 // FIFO下一条记录的位置
+	tail int64
+	size int
+}
+
+// openDiskQueue 打开队列文件，文件末尾不完整的记录将被丢弃
+func openDiskQueue(path string, lifo bool) (*diskQueue, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	q := &diskQueue{f: f, path: path, lifo: lifo}
+	if !lifo {
+		if data, err := os.ReadFile(path + ".head"); err == nil {
+			q.head, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		}
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	end := info.Size()
+	if q.head > end {
+		q.head = 0
+	}
+
+	q.tail = q.head
+	buf := make([]byte, 4)
+	for q.tail+8 <= end {
+		if _, err = f.ReadAt(buf, q.tail); err != nil {
+			break
+		}
+		next := q.tail + 8 + int64(binary.BigEndian.Uint32(buf))
+		if next > end {
+			break
+		}
+		q.tail = next
+		q.size++
+	}
+	if q.tail != end {
+		if err = f.Truncate(q.tail); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+func (q *diskQueue) push(data []byte) error {
+	record := make([]byte, len(data)+8)
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	copy(record[4:], data)
+	binary.BigEndian.PutUint32(record[len(data)+4:], uint32(len(data)))
+	if _, err := q.f.WriteAt(record, q.tail); err != nil {
+		return err
+	}
+	q.tail += int64(len(record))
+	q.size++
+	return nil
+}
+
+// peek 读取下一条记录，返回记录数据及其在文件中的起始位置
+func (q *diskQueue) peek() ([]byte, int64, error) {
+	if q.size == 0 {
+		return nil, 0, nil
+	}
+	buf := make([]byte, 4)
+	var offset int64
+	if q.lifo {
+		if _, err := q.f.ReadAt(buf, q.tail-4); err != nil {
+			return nil, 0, err
+		}
+		offset = q.tail - 8 - int64(binary.BigEndian.Uint32(buf))
+	} else {
+		if _, err := q.f.ReadAt(buf, q.head); err != nil {
+			return nil, 0, err
+		}
+		offset = q.head
+	}
+	data := make([]byte, binary.BigEndian.Uint32(buf))
+	if _, err := q.f.ReadAt(data, offset+4); err != nil {
+		return nil, 0, err
+	}
+	return data, offset, nil
+}
+
+func (q *diskQueue) pop() ([]byte, error) {
+	data, offset, err := q.peek()
+	if err != nil || data == nil {
+		return nil, err
+	}
+	q.size--
+	if q.lifo {
+		q.tail = offset
+		return data, q.f.Truncate(q.tail)
+	}
+	q.head = offset + 8 + int64(len(data))
+	// 队列为空时清空文件，避免文件无限增长。
+	// 先删除保存的读取位置，否则异常退出后会从旧位置读取新写入的记录，导致记录错位并被截断
+	if q.size == 0 {
+		q.head, q.tail = 0, 0
+		if err := os.Remove(q.path + ".head"); err != nil && !os.IsNotExist(err) {
+			return data, err
+		}
+		return data, q.f.Truncate(0)
+	}
+	return data, nil
+}
+
+// flush 将文件写入磁盘并保存FIFO的读取位置
+func (q *diskQueue) flush() error {
+	if err := q.f.Sync(); err != nil {
+		return err
+	}
+	if q.lifo {
+		return nil
+	}
+	tmp := q.path + ".head.tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(q.head, 10)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path+".head")
+}
+
+// close 关闭队列，队列为空时删除文件
+func (q *diskQueue) close() error {
+	err := q.flush()
+	if closeErr := q.f.Close(); err == nil {
+		err = closeErr
+	}
+	if q.size == 0 {
+		_ = os.Remove(q.path)
+		_ = os.Remove(q.path + ".head")
+	}
+	return err
+}
+
+// BaseDiskPriorityQueue 保存在JOBDIR/requests.queue中的优先级队列，每个优先级对应一个队列文件，
+// 只能保存*Request，Request通过ToJsonMap序列化。
+// FIFO队列的读取位置在Flush和Close时保存，异常退出后再次运行可能重复处理部分Request
+type BaseDiskPriorityQueue struct {
+	BaseSpiderModule
+	dir    string
+	lifo   bool
+	queues map[int]*diskQueue
+	mu     sync.Mutex
+}
+
+func (q *BaseDiskPriorityQueue) init(spider *Spider, name string, lifo bool) {
+	InitBaseSpiderModule(&q.BaseSpiderModule, spider, name)
+	jobdir := container.GetWithDefault[string](spider.Settings, "JOBDIR", "")
+	if jobdir == "" {
+		q.Logger.Fatal("使用磁盘队列时必须设置JOBDIR")
+	}
+	q.dir = filepath.Join(jobdir, "requests.queue")
+	q.lifo = lifo
+	q.queues = make(map[int]*diskQueue)
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		q.Logger.Fatalw("创建队列目录失败", "dir", q.dir, "error", err)
+	}
+
+	// 恢复上次运行未处理完的Request
+	paths, _ := filepath.Glob(filepath.Join(q.dir, "*.queue"))
+	for _, path := range paths {
+		priority, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), ".queue"))
+		if err != nil {
+			continue
+		}
+		dq, err := openDiskQueue(path, lifo)
+		if err != nil {
+			q.Logger.Fatalw("打开队列文件失败", "path", path, "error", err)
+		}
+		q.queues[priority] = dq
+	}
+	if size := q.Len(); size > 0 {
+		q.Logger.Infow("从磁盘恢复Request", "dir", q.dir, "count", size)
+	}
+	q.Logger.Info("模块初始化完成")
+}
+
+func (q *BaseDiskPriorityQueue) Push(value any, priority int) {
+	request, ok := value.(*Request)
+	if !ok {
+		q.Logger.Fatalw("磁盘队列只能保存Request", "type", value)
+	}
+	data, err := request.ToJsonMap().Dumps()
+	if err != nil {
+		RequestLogger(q.Logger, request).Errorw("Request序列化失败", "error", err)
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dq, ok := q.queues[priority]
+	if !ok {
+		dq, err = openDiskQueue(filepath.Join(q.dir, strconv.Itoa(priority)+".queue"), q.lifo)
+		if err != nil {
+			RequestLogger(q.Logger, request).Errorw("打开队列文件失败", "error", err)
+			return
+		}
+		q.queues[priority] = dq
+	}
+	if err = dq.push(data); err != nil {
+		RequestLogger(q.Logger, request).Errorw("Request写入磁盘队列失败", "error", err)
+		return
+	}
+	q.Stats.IncValue("queue/push", 1, 0)
+	q.Stats.IncValue("queue/disk/push", 1, 0)
+}
+
+// top 优先级最高的非空队列
+func (q *BaseDiskPriorityQueue) top() *diskQueue {
+	var res *diskQueue
+	best := 0
+	for priority, dq := range q.queues {
+		if dq.size > 0 && (res == nil || priority > best) {
+			res, best = dq, priority
+		}
+	}
+	return res
+}
+
+func decodeRequest(data []byte) (*Request, error) {
+	d := container.NewSyncJsonMap()
+	if err := d.Loads(data); err != nil {
+		return nil, err
+	}
+	return NewRequestFromJsonMap(d), nil
+}
+
+func (q *BaseDiskPriorityQueue) Pop() any {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dq := q.top()
+	if dq == nil {
+		return nil
+	}
+	data, err := dq.pop()
+	if err == nil {
+		var request *Request
+		if request, err = decodeRequest(data); err == nil {
+			q.Stats.IncValue("queue/pop", 1, 0)
+			q.Stats.IncValue("queue/disk/pop", 1, 0)
+			return request
+		}
+	}
+	q.Logger.Errorw("从磁盘队列读取Request失败", "path", dq.path, "error", err)
+	return nil
+}
+
+func (q *BaseDiskPriorityQueue) Peek() any {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dq := q.top()
+	if dq == nil {
+		return nil
+	}
+	data, _, err := dq.peek()
+	if err != nil {
+		q.Logger.Errorw("从磁盘队列读取Request失败", "path", dq.path, "error", err)
+		return nil
+	}
+	request, err := decodeRequest(data)
+	if err != nil {
+		q.Logger.Errorw("从磁盘队列读取Request失败", "path", dq.path, "error", err)
+		return nil
+	}
+	return request
+}
+
+func (q *BaseDiskPriorityQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	size := 0
+	for _, dq := range q.queues {
+		size += dq.size
+	}
+	return size
+}
+
+func (q *BaseDiskPriorityQueue) Flush() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var errs []error
+	for _, dq := range q.queues {
+		errs = append(errs, dq.flush())
+	}
+	return errors.Join(errs...)
+}
+
+func (q *BaseDiskPriorityQueue) Close(spider *Spider) {
+	size := q.Len()
+	q.mu.Lock()
+	for _, dq := range q.queues {
+		if err := dq.close(); err != nil {
+			q.Logger.Errorw("关闭队列文件失败", "path", dq.path, "error", err)
+		}
+	}
+	q.mu.Unlock()
+	if size > 0 {
+		q.Logger.Infow("未处理的Request已保存到磁盘", "dir", q.dir, "count", size)
+	}
+	q.BaseSpiderModule.Close(spider)
+}
+
+// FIFODiskPriorityQueue 优先级相同时 FIFO
+type FIFODiskPriorityQueue struct {
+	BaseDiskPriorityQueue
+}
+
+func (q *FIFODiskPriorityQueue) Name() string {
+	return "FIFODiskPriorityQueue"
+}
+
+func (q *FIFODiskPriorityQueue) FromSpider(spider *Spider) {
+	q.init(spider, q.Name(), false)
+}
+
+// LIFODiskPriorityQueue 优先级相同时 LIFO
+type LIFODiskPriorityQueue struct {
+	BaseDiskPriorityQueue
+}
+
+func (q *LIFODiskPriorityQueue) Name() string {
+	return "LIFODiskPriorityQueue"
+}
+
+func (q *LIFODiskPriorityQueue) FromSpider(spider *Spider) {
+	q.init(spider, q.Name(), true)
+}
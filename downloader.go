@@ -1,8 +1,17 @@
 package xspider
 
 import (
+	"container/list"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
-	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/xue0228/xspider/container"
@@ -12,8 +21,38 @@ func init() {
 	RegisterSpiderModuler(&DownloaderImpl{})
 }
 
+// transportKey 区分不同Transport的参数，参数相同的Request共享同一个连接池
+type transportKey struct {
+	proxy       string
+	proxyAuth   string
+	bindAddress string
+	tlsVerify   bool
+}
+
+// transportEntry LRU列表中的Transport
+type transportEntry struct {
+	key       transportKey
+	transport *http.Transport
+}
+
 type DownloaderImpl struct {
 	BaseSpiderModule
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	tlsHandshakeTimeout time.Duration
+	http2Enabled        bool
+	tlsVerify           bool
+	maxSize             int
+	warnSize            int
+	tempDir             string
+	tempFiles           []string
+	dnsOverrides        map[string]string
+	// 按最近使用顺序保存的Transport，超过maxTransports时关闭最久未使用的Transport
+	maxTransports int
+	transports    map[transportKey]*list.Element
+	transportLRU  *list.List
+	mu            sync.Mutex
 }
 
 func (d *DownloaderImpl) Name() string {
@@ -22,47 +61,192 @@ func (d *DownloaderImpl) Name() string {
 
 func (d *DownloaderImpl) FromSpider(spider *Spider) {
 	InitBaseSpiderModule(&d.BaseSpiderModule, spider, d.Name())
+	d.maxIdleConns = container.GetWithDefault[int](spider.Settings, "DOWNLOAD_MAX_IDLE_CONNS", 100)
+	d.maxIdleConnsPerHost = container.GetWithDefault[int](spider.Settings, "DOWNLOAD_MAX_IDLE_CONNS_PER_HOST", 16)
+	idleConnTimeout := container.GetWithDefault[int](spider.Settings, "DOWNLOAD_IDLE_CONN_TIMEOUT", 90)
+	d.idleConnTimeout = time.Duration(idleConnTimeout) * time.Second
+	tlsHandshakeTimeout := container.GetWithDefault[int](spider.Settings, "DOWNLOAD_TLS_HANDSHAKE_TIMEOUT", 10)
+	d.tlsHandshakeTimeout = time.Duration(tlsHandshakeTimeout) * time.Second
+	d.http2Enabled = container.GetWithDefault[bool](spider.Settings, "DOWNLOAD_HTTP2_ENABLED", true)
+	d.tlsVerify = container.GetWithDefault[bool](spider.Settings, "DOWNLOAD_TLS_VERIFY", true)
+	d.maxSize = container.GetWithDefault[int](spider.Settings, "DOWNLOAD_MAXSIZE", 1073741824)
+	d.warnSize = container.GetWithDefault[int](spider.Settings, "DOWNLOAD_WARNSIZE", 33554432)
+	// 未设置DOWNLOAD_TEMP_DIR时使用系统临时目录
+	d.tempDir = container.GetWithDefault[string](spider.Settings, "DOWNLOAD_TEMP_DIR", "")
+	if d.tempDir == "" {
+		d.tempDir = filepath.Join(os.TempDir(), "xspider")
+	}
+	d.dnsOverrides = make(map[string]string)
+	for host, ip := range container.GetWithDefault[map[string]string](spider.Settings, "DNS_OVERRIDES", map[string]string{}) {
+		d.dnsOverrides[strings.ToLower(host)] = ip
+	}
+	d.maxTransports = container.GetWithDefault[int](spider.Settings, "DOWNLOAD_MAX_TRANSPORTS", 100)
+	d.transports = make(map[transportKey]*list.Element)
+	d.transportLRU = list.New()
 	d.Logger.Info("模块初始化完成")
 }
 
-func (d *DownloaderImpl) Fetch(request *Request, spider *Spider) (*Response, error) {
-	//创建网络请求客户端
-	client := &http.Client{
-		//超时
-		Timeout: time.Duration(container.GetWithDefault[int](request.Ctx, "download_timeout", 180)) * time.Second,
-		//重定向
-		//CheckRedirect: NewCheckRedirect(r),
-	}
-	//添加代理
-	//proxy := request.Ctx.GetStringWithDefault("proxy", "")
-	proxy := container.GetWithDefault(request.Ctx, "proxy", "")
-	if proxy != "" {
-		proxyUrl, err := url.Parse(proxy)
-		if err != nil {
-			return nil, err
-		}
-		trans := &http.Transport{
-			Proxy: http.ProxyURL(proxyUrl),
-		}
-		client.Transport = trans
+// transport 获取key对应的Transport，不存在时新建
+func (d *DownloaderImpl) transport(key transportKey) (*http.Transport, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, ok := d.transports[key]; ok {
+		d.transportLRU.MoveToFront(e)
+		return e.Value.(*transportEntry).transport, nil
 	}
 
+	proxy, dial, err := d.dialer(key)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dial,
+		MaxIdleConns:          d.maxIdleConns,
+		MaxIdleConnsPerHost:   d.maxIdleConnsPerHost,
+		IdleConnTimeout:       d.idleConnTimeout,
+		TLSHandshakeTimeout:   d.tlsHandshakeTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		ForceAttemptHTTP2:     d.http2Enabled,
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: !key.tlsVerify},
+	}
+	if key.proxyAuth != "" {
+		t.ProxyConnectHeader = http.Header{"Proxy-Authorization": {key.proxyAuth}}
+	}
+	if !d.http2Enabled {
+		// TLSNextProto为非nil的空map时禁用HTTP/2
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	d.transports[key] = d.transportLRU.PushFront(&transportEntry{key: key, transport: t})
+	d.Logger.Debugw("新建Transport", "proxy", redactProxy(key.proxy), "bind_address", key.bindAddress, "tls_verify", key.tlsVerify)
+	// 代理池较大时每个代理各有一个Transport，超过DOWNLOAD_MAX_TRANSPORTS时关闭最久未使用的，0表示不限制。
+	// 正在使用被关闭Transport的下载不受影响，之后再次使用该代理时重新创建
+	if d.maxTransports > 0 && d.transportLRU.Len() > d.maxTransports {
+		oldest := d.transportLRU.Remove(d.transportLRU.Back()).(*transportEntry)
+		delete(d.transports, oldest.key)
+		oldest.transport.CloseIdleConnections()
+		d.Logger.Debugw("关闭最久未使用的Transport", "proxy", redactProxy(oldest.key.proxy), "bind_address", oldest.key.bindAddress)
+	}
+	return t, nil
+}
+
+func (d *DownloaderImpl) Fetch(request *Request, spider *Spider) (*Response, error) {
 	//创建http.Request请求
 	req, err := http.NewRequest(request.Method, request.Url.String(), request.Body)
 	if err != nil {
 		return nil, err
 	}
-	//向请求头中添加Cookies
-	req.Header = *request.Headers
+	//向请求头中添加Cookies，复制请求头以免修改Request本身
+	req.Header = request.Headers.Clone()
 	for _, v := range request.Cookies {
 		req.AddCookie(v)
 	}
 
-	//发送网络请求
+	key := transportKey{
+		proxy:       container.GetWithDefault(request.Ctx, "proxy", ""),
+		bindAddress: container.GetWithDefault(request.Ctx, "bindaddress", ""),
+		tlsVerify:   container.GetWithDefault(request.Ctx, "tls_verify", d.tlsVerify),
+	}
+	//HTTPS请求通过CONNECT隧道访问HTTP代理，Proxy-Authorization需要在CONNECT请求中发送，
+	//不使用HTTP代理时也不能转发给目标网站
+	httpProxy := strings.HasPrefix(key.proxy, "http://") || strings.HasPrefix(key.proxy, "https://")
+	if !httpProxy || request.Url.Scheme == "https" {
+		if httpProxy {
+			key.proxyAuth = req.Header.Get("Proxy-Authorization")
+		}
+		req.Header.Del("Proxy-Authorization")
+	}
+
+	//获取连接池
+	trans, err := d.transport(key)
+	if err != nil {
+		return nil, err
+	}
+
+	//创建网络请求客户端，Client本身很轻量，连接复用由共享的Transport完成
+	client := &http.Client{
+		Transport: trans,
+		//超时
+		Timeout: time.Duration(container.GetWithDefault[int](request.Ctx, "download_timeout", 180)) * time.Second,
+		//禁用自动重定向，3xx响应原样返回，由RedirectDownloaderMiddleware处理
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	//下载到文件时，存在可以校验的未完成文件则使用Range断点续传
+	path, toFile, err := d.downloadPath(request)
+	if err != nil {
+		return nil, err
+	}
+	toFile = toFile && request.Method != http.MethodHead
+	var offset int64
+	if toFile && request.Method == http.MethodGet {
+		if size, validator := readPartMeta(path); size > 0 && validator != "" {
+			offset = size
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			req.Header.Set("If-Range", validator)
+		}
+	}
+
+	//发送网络请求，收到响应头的时间作为下载延迟（秒）保存在Ctx的download_latency中
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	container.Set(request.Ctx, "download_latency", time.Since(start).Seconds())
+
+	if offset > 0 {
+		switch {
+		case resp.StatusCode == http.StatusPartialContent && contentRangeStart(resp.Header.Get("Content-Range")) == offset:
+		case resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+			//续传范围不正确，删除未完成的文件后重新下载
+			_ = resp.Body.Close()
+			removePart(path)
+			d.Stats.IncValue("download/resume_failed", 1, 0)
+			RequestLogger(d.Logger, request).Warnw("断点续传失败，重新下载", "status_code", resp.StatusCode)
+			return d.Fetch(request, spider)
+		default:
+			//文件已变化或服务器不支持Range，返回完整的响应体
+			offset = 0
+		}
+	}
+
+	//检查Response大小，超过download_maxsize时不再继续下载
+	maxSize := container.GetWithDefault[int](request.Ctx, "download_maxsize", d.maxSize)
+	warnSize := container.GetWithDefault[int](request.Ctx, "download_warnsize", d.warnSize)
+	logger := RequestLogger(d.Logger, request)
+	contentLength := resp.ContentLength
+	if contentLength >= 0 {
+		contentLength += offset
+	}
+	if maxSize > 0 && contentLength > int64(maxSize) {
+		_ = resp.Body.Close()
+		logger.Errorw("Response的Content-Length超过最大限制",
+			"content_length", contentLength, "download_maxsize", maxSize)
+		return nil, &DownloadSizeError{Url: request.Url.String(), Size: int(contentLength), MaxSize: maxSize}
+	}
+	if warnSize > 0 && contentLength > int64(warnSize) {
+		logger.Warnw("Response的Content-Length超过警告大小",
+			"content_length", contentLength, "download_warnsize", warnSize)
+		//已经警告过，读取时不再重复警告
+		warnSize = 0
+	}
+	body := newSizeLimitReader(resp.Body, request.Url.String(), maxSize, warnSize, func(size int) {
+		logger.Warnw("已下载的Response大小超过警告大小", "size", size, "download_warnsize", warnSize)
+	})
+	//续传时已下载的部分同样计入大小限制
+	body.size = int(offset)
+	resp.Body = body
+
+	//只有完整的响应体才写入文件，其他状态码的响应体仍保存在内存中
+	if toFile && (resp.StatusCode == http.StatusOK || offset > 0) {
+		return d.fetchToFile(resp, request, path, offset)
+	}
 
 	res, err := NewResponseWithRequest(resp, request)
 	if err != nil {
@@ -71,3 +255,199 @@ func (d *DownloaderImpl) Fetch(request *Request, spider *Spider) (*Response, err
 
 	return res, nil
 }
+
+// downloadPath 解析Ctx中的download_to_file，字符串为保存路径，true时保存到DOWNLOAD_TEMP_DIR下新建的临时文件，
+// 临时文件的路径保存在Ctx的download_temp_file中，重试时继续使用同一个文件以便断点续传
+func (d *DownloaderImpl) downloadPath(request *Request) (string, bool, error) {
+	v, err := request.Ctx.Get("download_to_file")
+	if err != nil {
+		return "", false, nil
+	}
+	switch v := v.(type) {
+	case string:
+		return v, v != "", nil
+	case bool:
+		if !v {
+			return "", false, nil
+		}
+		if path := container.GetWithDefault[string](request.Ctx, "download_temp_file", ""); path != "" {
+			return path, true, nil
+		}
+		if err = os.MkdirAll(d.tempDir, 0777); err != nil {
+			return "", false, err
+		}
+		f, err := os.CreateTemp(d.tempDir, "download-*")
+		if err != nil {
+			return "", false, err
+		}
+		_ = f.Close()
+		d.mu.Lock()
+		d.tempFiles = append(d.tempFiles, f.Name())
+		d.mu.Unlock()
+		container.Set(request.Ctx, "download_temp_file", f.Name())
+		return f.Name(), true, nil
+	default:
+		return "", false, nil
+	}
+}
+
+// fetchToFile 将响应体写入path，先写入.part文件，下载完成后再重命名，offset大于0时追加到已下载的部分之后
+func (d *DownloaderImpl) fetchToFile(resp *http.Response, request *Request, path string, offset int64) (*Response, error) {
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(resp.Body)
+	logger := RequestLogger(d.Logger, request)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, err
+	}
+	part := path + ".part"
+	var f *os.File
+	var err error
+	resumable := true
+	if offset > 0 {
+		f, err = os.OpenFile(part, os.O_WRONLY|os.O_APPEND, 0666)
+		d.Stats.IncValue("download/resumed", 1, 0)
+		d.Stats.IncValue("download/resumed_bytes", int(offset), 0)
+		logger.Infow("断点续传", "file", path, "offset", offset)
+	} else {
+		f, err = os.Create(part)
+		if err == nil {
+			resumable, err = writePartMeta(path, resp.Header)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(io.MultiWriter(f, &statsWriter{stats: d.Stats, key: "download_to_file/bytes"}), resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		//可以校验的文件保留已下载的部分，重试时从断点继续下载
+		if !resumable {
+			removePart(path)
+		}
+		return nil, err
+	}
+	if err = os.Rename(part, path); err != nil {
+		return nil, err
+	}
+	_ = os.Remove(part + ".meta")
+
+	size := offset + n
+	if offset > 0 {
+		//续传完成后的Response与完整下载的Response保持一致
+		resp.StatusCode = http.StatusOK
+		resp.Header.Del("Content-Range")
+		resp.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+		container.Set(request.Ctx, "download_resumed_from", int(offset))
+	}
+	d.Stats.IncValue("download_to_file/count", 1, 0)
+	logger.Debugw("响应体已写入文件", "file", path, "size", size)
+	return &Response{
+		StatusCode: resp.StatusCode,
+		BodyFile:   path,
+		BodySize:   int(size),
+		Ctx:        request.Ctx,
+		Request:    request,
+		Headers:    &resp.Header,
+	}, nil
+}
+
+// partMeta 未完成文件的校验信息，保存在.part.meta文件中
+type partMeta struct {
+	ETag         string `json:"etag"`
+	LastModified string `json:"last_modified"`
+}
+
+// writePartMeta 保存响应的ETag和Last-Modified，没有可用于If-Range的校验信息时返回false
+func writePartMeta(path string, header http.Header) (bool, error) {
+	meta := partMeta{LastModified: header.Get("Last-Modified")}
+	//If-Range不能使用弱ETag
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		meta.ETag = etag
+	}
+	if meta.ETag == "" && meta.LastModified == "" {
+		_ = os.Remove(path + ".part.meta")
+		return false, nil
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(path+".part.meta", data, 0666)
+}
+
+// readPartMeta 返回未完成文件已下载的大小和If-Range使用的校验信息
+func readPartMeta(path string) (int64, string) {
+	info, err := os.Stat(path + ".part")
+	if err != nil {
+		return 0, ""
+	}
+	data, err := os.ReadFile(path + ".part.meta")
+	if err != nil {
+		return 0, ""
+	}
+	var meta partMeta
+	if err = json.Unmarshal(data, &meta); err != nil {
+		return 0, ""
+	}
+	if meta.ETag != "" {
+		return info.Size(), meta.ETag
+	}
+	return info.Size(), meta.LastModified
+}
+
+// removePart 删除未完成的文件及其校验信息
+func removePart(path string) {
+	_ = os.Remove(path + ".part")
+	_ = os.Remove(path + ".part.meta")
+}
+
+// contentRangeStart 解析Content-Range的起始位置，格式为bytes start-end/total
+func contentRangeStart(value string) int64 {
+	value, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return -1
+	}
+	start, _, ok := strings.Cut(value, "-")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// statsWriter 将写入的字节数累加到Stats中，用于统计下载进度
+type statsWriter struct {
+	stats Statser
+	key   string
+}
+
+func (w *statsWriter) Write(p []byte) (int, error) {
+	w.stats.IncValue(w.key, len(p), 0)
+	return len(p), nil
+}
+
+func (d *DownloaderImpl) Close(spider *Spider) {
+	d.mu.Lock()
+	for _, e := range d.transports {
+		e.Value.(*transportEntry).transport.CloseIdleConnections()
+	}
+	d.transports = make(map[transportKey]*list.Element)
+	d.transportLRU.Init()
+	//删除download_to_file为true时生成的临时文件
+	for _, path := range d.tempFiles {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			d.Logger.Warnw("删除临时文件失败", "file", path, "error", err)
+		}
+		removePart(path)
+	}
+	d.tempFiles = nil
+	d.mu.Unlock()
+	d.BaseSpiderModule.Close(spider)
+}
@@ -0,0 +1,153 @@
+package xspider
+
+import (
+	"sync"
+
+	"github.com/emirpasic/gods/queues/priorityqueue"
+	"github.com/emirpasic/gods/utils"
+	"github.com/xue0228/xspider/container"
+)
+
+func init() {
+	RegisterSpiderModuler(&DownloaderAwarePriorityQueue{})
+}
+
+// DownloaderAwarePriorityQueue 按Request的SlotKey分别保存Request，出队时优先选择下载slot中Request最少的子队列，
+// 数量相同时选择优先级较高的，避免单个域名的大量Request占满下载器。
+// DOWNLOADER_AWARE_QUEUE_STRUCT为FIFOPriorityQueue或LIFOPriorityQueue，指定优先级相同时子队列的出队顺序。
+// 子队列保存在内存中，为空时删除
+type DownloaderAwarePriorityQueue struct {
+	BaseSpiderModule
+	spider  *Spider
+	compare utils.Comparator
+	queues  map[string]*slotQueue
+	mu      sync.Mutex
+}
+
+// slotQueue 单个下载slot的子队列
+type slotQueue struct {
+	queue   *priorityqueue.Queue
+	counter int64
+}
+
+func (sq *slotQueue) push(request *Request, priority int) {
+	sq.counter++
+	sq.queue.Enqueue(&queueElement{value: request, priority: priority, timestamp: sq.counter})
+}
+
+func (sq *slotQueue) peek() *Request {
+	value, ok := sq.queue.Peek()
+	if !ok {
+		return nil
+	}
+	return value.(*queueElement).value.(*Request)
+}
+
+func (sq *slotQueue) pop() *Request {
+	value, ok := sq.queue.Dequeue()
+	if !ok {
+		return nil
+	}
+	return value.(*queueElement).value.(*Request)
+}
+
+func (q *DownloaderAwarePriorityQueue) Name() string {
+	return "DownloaderAwarePriorityQueue"
+}
+
+func (q *DownloaderAwarePriorityQueue) FromSpider(spider *Spider) {
+	InitBaseSpiderModule(&q.BaseSpiderModule, spider, q.Name())
+	if spider.requestSlot == nil {
+		q.Logger.Fatal("DownloaderAwarePriorityQueue必须在RequestSlot初始化之后使用")
+	}
+	q.spider = spider
+	queueStruct := container.GetWithDefault[string](spider.Settings, "DOWNLOADER_AWARE_QUEUE_STRUCT", "LIFOPriorityQueue")
+	switch queueStruct {
+	case "FIFOPriorityQueue":
+		q.compare = fifoCompare
+	case "LIFOPriorityQueue":
+		q.compare = lifoCompare
+	default:
+		q.Logger.Fatalw("DOWNLOADER_AWARE_QUEUE_STRUCT只能为FIFOPriorityQueue或LIFOPriorityQueue", "queue_struct", queueStruct)
+	}
+	q.queues = make(map[string]*slotQueue)
+	q.Logger.Infow("模块初始化完成", "queue_struct", queueStruct)
+}
+
+func (q *DownloaderAwarePriorityQueue) Push(value any, priority int) {
+	request, ok := value.(*Request)
+	if !ok {
+		q.Logger.Fatalw("DownloaderAwarePriorityQueue只能保存Request", "type", value)
+	}
+
+	key := q.spider.requestSlot.SlotKey(request)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queue, ok := q.queues[key]
+	if !ok {
+		queue = &slotQueue{queue: priorityqueue.NewWith(q.compare)}
+		q.queues[key] = queue
+		q.Stats.MaxValue("queue/downloader_aware/max_slots", len(q.queues))
+	}
+	queue.push(request, priority)
+	q.Stats.IncValue("queue/push", 1, 0)
+}
+
+// next 下载slot中Request最少的非空子队列
+func (q *DownloaderAwarePriorityQueue) next() (string, *slotQueue) {
+	var (
+		key      string
+		queue    *slotQueue
+		minLen   int
+		priority int
+	)
+	for k, sq := range q.queues {
+		request := sq.peek()
+		if request == nil {
+			continue
+		}
+		n := q.spider.requestSlot.SlotLen(k)
+		if queue == nil || n < minLen || n == minLen && request.Priority > priority {
+			key, queue, minLen, priority = k, sq, n, request.Priority
+		}
+	}
+	return key, queue
+}
+
+func (q *DownloaderAwarePriorityQueue) Pop() any {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key, queue := q.next()
+	if queue == nil {
+		return nil
+	}
+	request := queue.pop()
+	if queue.queue.Empty() {
+		delete(q.queues, key)
+	}
+	q.Stats.IncValue("queue/pop", 1, 0)
+	return request
+}
+
+func (q *DownloaderAwarePriorityQueue) Peek() any {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, queue := q.next()
+	if queue == nil {
+		return nil
+	}
+	return queue.peek()
+}
+
+func (q *DownloaderAwarePriorityQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	size := 0
+	for _, queue := range q.queues {
+		size += queue.queue.Size()
+	}
+	return size
+}
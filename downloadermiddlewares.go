@@ -1,11 +1,21 @@
 package xspider
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/emirpasic/gods/sets/hashset"
 	"github.com/xue0228/xspider/container"
+	"github.com/xue0228/xspider/robotstxt"
 	"go.uber.org/zap"
 )
 
@@ -16,6 +26,12 @@ func init() {
 	RegisterSpiderModuler(&DefaultHeadersDownloaderMiddleware{})
 	RegisterSpiderModuler(&RetryDownloaderMiddleware{})
 	RegisterSpiderModuler(&DownloaderStatsDownloaderMiddleware{})
+	RegisterSpiderModuler(&RedirectDownloaderMiddleware{})
+	RegisterSpiderModuler(&CookiesDownloaderMiddleware{})
+	RegisterSpiderModuler(&HttpCompressionDownloaderMiddleware{})
+	RegisterSpiderModuler(&HttpProxyDownloaderMiddleware{})
+	RegisterSpiderModuler(&RobotsTxtDownloaderMiddleware{})
+	RegisterSpiderModuler(&HttpCacheDownloaderMiddleware{})
 }
 
 type HttpAuthDownloaderMiddleware struct {
@@ -61,14 +77,16 @@ func (dm *HttpAuthDownloaderMiddleware) FromSpider(spider *Spider) {
 
 func (dm *HttpAuthDownloaderMiddleware) ProcessRequest(request *Request, spider *Spider) Result {
 	if dm.auth != "" {
-		domain := request.Domain()
+		host := request.Host()
 		if dm.domainUnset {
-			dm.domains = []string{domain}
+			dm.domains = []string{host}
 			dm.domainUnset = false
 		}
+		// 按主机名匹配，域名的子域名同样携带认证信息
 		for _, v := range dm.domains {
-			if strings.ToLower(v) == domain {
+			if IsSubdomain(host, v) {
 				request.Headers.Set("Authorization", dm.auth)
+				break
 			}
 		}
 	}
@@ -161,6 +179,10 @@ func (dm *DefaultHeadersDownloaderMiddleware) ProcessRequest(request *Request, s
 	return nil
 }
 
+// RetryDownloaderMiddleware 重试出错或状态码在RETRY_HTTP_CODES中的Request。
+// 重试前暂停Request所在的slot：Response中有Retry-After时使用其指定的时间，
+// 否则RETRY_BACKOFF_BASE大于0时使用指数退避，第n次重试等待RETRY_BACKOFF_BASE*2^(n-1)秒并加入随机抖动，
+// 等待时间不超过RETRY_BACKOFF_MAX秒
 type RetryDownloaderMiddleware struct {
 	BaseDownloaderMiddleware
 	retryEnabled   bool
@@ -168,6 +190,8 @@ type RetryDownloaderMiddleware struct {
 	priorityAdjust int
 	retryHttpCodes *hashset.Set
 	retryReasons   *hashset.Set
+	backoffBase    time.Duration
+	backoffMax     time.Duration
 }
 
 func (dm *RetryDownloaderMiddleware) Name() string {
@@ -185,6 +209,8 @@ func (dm *RetryDownloaderMiddleware) FromSpider(spider *Spider) {
 	dm.maxRetryTimes = container.GetWithDefault[int](spider.Settings, "RETRY_TIMES", 2)
 	//dm.priorityAdjust = spider.Settings.GetIntWithDefault("RETRY_PRIORITY_ADJUST", -1)
 	dm.priorityAdjust = container.GetWithDefault[int](spider.Settings, "RETRY_PRIORITY_ADJUST", -1)
+	dm.backoffBase = SecondsToDuration(container.GetWithDefault[float64](spider.Settings, "RETRY_BACKOFF_BASE", 0))
+	dm.backoffMax = SecondsToDuration(container.GetWithDefault[float64](spider.Settings, "RETRY_BACKOFF_MAX", 300))
 	//codes := spider.Settings.GetWithDefault("RETRY_HTTP_CODES", []any{500, 502, 503, 504, 522, 524, 408, 429})
 	codes := container.GetWithDefault[[]int](spider.Settings, "RETRY_HTTP_CODES", []int{500, 502, 503, 504, 522, 524, 408, 429})
 	for _, v := range codes {
@@ -217,6 +243,8 @@ func (dm *RetryDownloaderMiddleware) FromSpider(spider *Spider) {
 		"os.SyscallError",
 		"net.InvalidAddrError",
 		"net.UnknownNetworkError",
+		//响应体未完整接收，下载到文件时重试可以断点续传
+		"unexpected_EOF",
 	})
 	for _, v := range reasons {
 		dm.retryReasons.Add(v)
@@ -231,6 +259,12 @@ func (dm *RetryDownloaderMiddleware) ProcessResponse(request *Request, response
 	if dm.retryHttpCodes.Contains(response.StatusCode) {
 		reason := response.StatusCode
 		req := dm.retry(request, fmt.Sprintf("%d", reason))
+		// 不再重试时也遵守Retry-After，避免同一站点的其他Request继续请求
+		delay := parseRetryAfter(response.Headers, time.Now())
+		if delay <= 0 && req != nil {
+			delay = dm.backoffDelay(req)
+		}
+		dm.backoff(request, delay, SenderProcessResponse, spider)
 		if req == nil {
 			return response
 		} else {
@@ -241,6 +275,10 @@ func (dm *RetryDownloaderMiddleware) ProcessResponse(request *Request, response
 }
 
 func (dm *RetryDownloaderMiddleware) ProcessError(request *Request, err error, spider *Spider) Result {
+	// 超过大小限制的Response重试后结果相同，不再重试
+	if errors.Is(err, ErrDownloadMaxSize) {
+		return nil
+	}
 	reason := ErrorToReason(err)
 	if !dm.retryReasons.Contains(reason) {
 		return nil
@@ -249,9 +287,55 @@ func (dm *RetryDownloaderMiddleware) ProcessError(request *Request, err error, s
 	if req == nil {
 		return nil
 	}
+	dm.backoff(req, dm.backoffDelay(req), SenderProcessError, spider)
 	return req
 }
 
+// backoffDelay 根据重试次数计算指数退避的等待时间，在[d/2, d)之间随机
+func (dm *RetryDownloaderMiddleware) backoffDelay(request *Request) time.Duration {
+	if dm.backoffBase <= 0 {
+		return 0
+	}
+	retryTimes := max(container.GetWithDefault[int](request.Ctx, "retry_times", 1), 1)
+	delay := float64(dm.backoffBase) * math.Pow(2, float64(retryTimes-1))
+	if dm.backoffMax > 0 {
+		delay = min(delay, float64(dm.backoffMax))
+	}
+	return time.Duration(delay/2 + rand.Float64()*delay/2)
+}
+
+// backoff 暂停Request所在的slot
+func (dm *RetryDownloaderMiddleware) backoff(request *Request, delay time.Duration, sender Sender, spider *Spider) {
+	if dm.backoffMax > 0 {
+		delay = min(delay, dm.backoffMax)
+	}
+	if delay <= 0 {
+		return
+	}
+	dm.Stats.IncValue("retry/backoff/count", 1, 0)
+	dm.Stats.IncValue("retry/backoff/seconds", delay.Seconds(), 0.0)
+	RequestLogger(dm.Logger, request).Debugw("暂停下载后重试", "delay", delay.String())
+	spider.Signal.Emit(NewRequestSlotBackoffSignal(sender, request, delay, spider))
+}
+
+// parseRetryAfter 解析Retry-After响应头，可以是秒数或HTTP日期，没有或已过期时返回0
+func parseRetryAfter(headers *http.Header, now time.Time) time.Duration {
+	if headers == nil {
+		return 0
+	}
+	value := strings.TrimSpace(headers.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return max(SecondsToDuration(seconds), 0)
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
+
 func (dm *RetryDownloaderMiddleware) retry(request *Request, reason string) *Request {
 	//maxRetryTimes := request.Ctx.GetIntWithDefault("max_retry_times", dm.maxRetryTimes)
 	//priorityAdjust := request.Ctx.GetIntWithDefault("priority_adjust", dm.priorityAdjust)
@@ -295,6 +379,844 @@ func getRetryRequest(
 	}
 }
 
+type RedirectDownloaderMiddleware struct {
+	BaseDownloaderMiddleware
+	enabled          bool
+	maxRedirectTimes int
+	priorityAdjust   int
+	allowedDomains   []string
+}
+
+func (dm *RedirectDownloaderMiddleware) Name() string {
+	return "RedirectDownloaderMiddleware"
+}
+
+func (dm *RedirectDownloaderMiddleware) FromSpider(spider *Spider) {
+	InitBaseSpiderModule(&dm.BaseSpiderModule, spider, dm.Name())
+	dm.enabled = container.GetWithDefault[bool](spider.Settings, "REDIRECT_ENABLED", true)
+	dm.maxRedirectTimes = container.GetWithDefault[int](spider.Settings, "REDIRECT_MAX_TIMES", 20)
+	dm.priorityAdjust = container.GetWithDefault[int](spider.Settings, "REDIRECT_PRIORITY_ADJUST", 2)
+	dm.allowedDomains = container.GetWithDefault[[]string](spider.Settings, "ALLOWED_DOMAINS", []string{})
+}
+
+func isRedirectStatus(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	default:
+		return false
+	}
+}
+
+func (dm *RedirectDownloaderMiddleware) ProcessResponse(request *Request, response *Response, spider *Spider) Result {
+	if !dm.enabled || container.GetWithDefault[bool](request.Ctx, "dont_redirect", false) {
+		return response
+	}
+	if !isRedirectStatus(response.StatusCode) {
+		return response
+	}
+	// 用户明确要求处理的状态码不做重定向
+	if container.GetWithDefault[bool](request.Ctx, "handle_httpstatus_all", false) {
+		return response
+	}
+	if codes, err := container.Get[[]int](request.Ctx, "handle_httpstatus_list"); err == nil &&
+		slices.Contains(codes, response.StatusCode) {
+		return response
+	}
+
+	location := response.Headers.Get("Location")
+	if location == "" {
+		return response
+	}
+	u, err := request.Url.Parse(location)
+	if err != nil {
+		ResponseLogger(dm.Logger, response).Warnw("无法解析重定向地址", "location", location, "error", err)
+		return response
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return response
+	}
+
+	redirected := request.Copy()
+	redirected.Url = u
+	// 302、303以及POST的301重定向改为不带请求体的GET请求，307、308保持原请求方法
+	if (response.StatusCode == http.StatusFound || response.StatusCode == http.StatusSeeOther) && request.Method != http.MethodHead ||
+		response.StatusCode == http.StatusMovedPermanently && request.Method == http.MethodPost {
+		redirected.Method = http.MethodGet
+		redirected.Body = nil
+		redirected.Headers.Del("Content-Type")
+		redirected.Headers.Del("Content-Length")
+	}
+	// 跨域名时不再携带原请求的认证信息和Cookies
+	if u.Host != request.Url.Host || u.Scheme != request.Url.Scheme {
+		redirected.Headers.Del("Authorization")
+	}
+	if u.Hostname() != request.Url.Hostname() {
+		redirected.Headers.Del("Cookie")
+		redirected.Cookies = nil
+	}
+
+	return dm.redirect(request, redirected, response.StatusCode)
+}
+
+func (dm *RedirectDownloaderMiddleware) redirect(request *Request, redirected *Request, reason int) *Request {
+	logger := RequestLogger(dm.Logger, request).With("redirect_url", redirected.Url.String(), "reason", reason)
+
+	redirectTimes := container.GetWithDefault[int](request.Ctx, "redirect_times", 0) + 1
+	ttl := container.GetWithDefault[int](request.Ctx, "redirect_ttl", dm.maxRedirectTimes)
+	if ttl <= 0 || redirectTimes > dm.maxRedirectTimes {
+		dm.Stats.IncValue("redirect/max_reached", 1, 0)
+		logger.Errorw("Request重定向次数过多", "redirect_times", redirectTimes)
+		panic(ErrRedirectMaxReached)
+	}
+
+	if !IsDomainAllowed(redirected.Host(), dm.allowedDomains) {
+		dm.Stats.IncValue("redirect/offsite_filtered", 1, 0)
+		logger.Infow("重定向的域名不在允许的域名列表中", "allowed_domains", dm.allowedDomains)
+		panic(fmt.Errorf("%s: %w", redirected.Url.String(), ErrOffsiteRequest))
+	}
+
+	urls := container.GetWithDefault[[]string](request.Ctx, "redirect_urls", []string{})
+	reasons := container.GetWithDefault[[]int](request.Ctx, "redirect_reasons", []int{})
+	container.Set(redirected.Ctx, "redirect_times", redirectTimes)
+	container.Set(redirected.Ctx, "redirect_ttl", ttl-1)
+	container.Set(redirected.Ctx, "redirect_urls", append(urls, request.Url.String()))
+	container.Set(redirected.Ctx, "redirect_reasons", append(reasons, reason))
+	redirected.Priority = request.Priority + dm.priorityAdjust
+
+	dm.Stats.IncValue("redirect/count", 1, 0)
+	dm.Stats.IncValue(fmt.Sprintf("redirect/count/%d", reason), 1, 0)
+	logger.Debugw("重定向Request", "redirect_times", redirectTimes)
+	return redirected
+}
+
+// CookiesDownloaderMiddleware 使用Cookie Jar管理Cookie，
+// Request.Ctx中的cookiejar用于区分不同的会话，dont_merge_cookies为true时不处理该Request
+type CookiesDownloaderMiddleware struct {
+	BaseDownloaderMiddleware
+	enabled bool
+	debug   bool
+	file    string
+	jars    map[string]*persistentCookieJar
+	mu      sync.Mutex
+}
+
+func (dm *CookiesDownloaderMiddleware) Name() string {
+	return "CookiesDownloaderMiddleware"
+}
+
+func (dm *CookiesDownloaderMiddleware) FromSpider(spider *Spider) {
+	InitBaseSpiderModule(&dm.BaseSpiderModule, spider, dm.Name())
+	dm.enabled = container.GetWithDefault[bool](spider.Settings, "COOKIES_ENABLED", true)
+	dm.debug = container.GetWithDefault[bool](spider.Settings, "COOKIES_DEBUG", false)
+	dm.file = container.GetWithDefault[string](spider.Settings, "COOKIES_FILE", "")
+	dm.jars = make(map[string]*persistentCookieJar)
+	if dm.enabled && dm.file != "" {
+		jars, err := loadCookieJars(dm.file)
+		if err != nil {
+			dm.Logger.Errorw("读取Cookie文件失败", "file", dm.file, "error", err)
+		} else {
+			dm.jars = jars
+			dm.Logger.Infow("已读取Cookie文件", "file", dm.file, "sessions", len(jars))
+		}
+	}
+}
+
+// jar 获取Request所属会话的Cookie Jar，不存在时新建
+func (dm *CookiesDownloaderMiddleware) jar(request *Request) *persistentCookieJar {
+	name := "default"
+	if v, err := request.Ctx.Get("cookiejar"); err == nil && v != nil {
+		name = fmt.Sprint(v)
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	jar, ok := dm.jars[name]
+	if !ok {
+		jar = newPersistentCookieJar()
+		dm.jars[name] = jar
+	}
+	return jar
+}
+
+func (dm *CookiesDownloaderMiddleware) skip(request *Request) bool {
+	return !dm.enabled || container.GetWithDefault[bool](request.Ctx, "dont_merge_cookies", false)
+}
+
+func (dm *CookiesDownloaderMiddleware) ProcessRequest(request *Request, spider *Spider) Result {
+	if dm.skip(request) {
+		return nil
+	}
+
+	// Request自带的Cookies并入Jar，Jar中的Cookie与请求头中已有的Cookie合并，同名时使用Jar中的值
+	jar := dm.jar(request)
+	if len(request.Cookies) > 0 {
+		jar.SetCookies(request.Url, request.Cookies)
+		request.Cookies = nil
+	}
+	cookies := jar.Cookies(request.Url)
+	if len(cookies) == 0 {
+		return nil
+	}
+	names := make(map[string]bool, len(cookies))
+	pairs := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		names[c.Name] = true
+		pairs = append(pairs, c.Name+"="+c.Value)
+	}
+	for _, c := range (&http.Request{Header: *request.Headers}).Cookies() {
+		if !names[c.Name] {
+			pairs = append(pairs, c.Name+"="+c.Value)
+		}
+	}
+	header := strings.Join(pairs, "; ")
+	request.Headers.Set("Cookie", header)
+	if dm.debug {
+		RequestLogger(dm.Logger, request).Debugw("发送Cookie", "cookie", header)
+	}
+	return nil
+}
+
+func (dm *CookiesDownloaderMiddleware) ProcessResponse(request *Request, response *Response, spider *Spider) Result {
+	if dm.skip(request) {
+		return response
+	}
+
+	cookies := (&http.Response{Header: *response.Headers}).Cookies()
+	if len(cookies) == 0 {
+		return response
+	}
+	dm.jar(request).SetCookies(request.Url, cookies)
+	if dm.debug {
+		ResponseLogger(dm.Logger, response).Debugw("接收Cookie", "set-cookie", response.Headers.Values("Set-Cookie"))
+	}
+	return response
+}
+
+func (dm *CookiesDownloaderMiddleware) Flush() error {
+	if !dm.enabled || dm.file == "" {
+		return nil
+	}
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return saveCookieJars(dm.file, dm.jars)
+}
+
+func (dm *CookiesDownloaderMiddleware) Close(spider *Spider) {
+	if dm.enabled && dm.file != "" {
+		if err := dm.Flush(); err != nil {
+			dm.Logger.Errorw("保存Cookie文件失败", "file", dm.file, "error", err)
+		} else {
+			dm.Logger.Infow("已保存Cookie文件", "file", dm.file)
+		}
+	}
+	dm.BaseDownloaderMiddleware.Close(spider)
+}
+
+// HttpCompressionDownloaderMiddleware 发送Accept-Encoding并解压Response，
+// 解压后的大小受DOWNLOAD_MAXSIZE限制，防止解压炸弹
+type HttpCompressionDownloaderMiddleware struct {
+	BaseDownloaderMiddleware
+	enabled  bool
+	maxSize  int
+	warnSize int
+}
+
+func (dm *HttpCompressionDownloaderMiddleware) Name() string {
+	return "HttpCompressionDownloaderMiddleware"
+}
+
+func (dm *HttpCompressionDownloaderMiddleware) FromSpider(spider *Spider) {
+	InitBaseSpiderModule(&dm.BaseSpiderModule, spider, dm.Name())
+	dm.enabled = container.GetWithDefault[bool](spider.Settings, "COMPRESSION_ENABLED", true)
+	dm.maxSize = container.GetWithDefault[int](spider.Settings, "DOWNLOAD_MAXSIZE", 1073741824)
+	dm.warnSize = container.GetWithDefault[int](spider.Settings, "DOWNLOAD_WARNSIZE", 33554432)
+}
+
+func (dm *HttpCompressionDownloaderMiddleware) ProcessRequest(request *Request, spider *Spider) Result {
+	if dm.enabled && request.Headers.Get("Accept-Encoding") == "" {
+		request.Headers.Set("Accept-Encoding", AcceptEncoding)
+	}
+	return nil
+}
+
+func (dm *HttpCompressionDownloaderMiddleware) ProcessResponse(request *Request, response *Response, spider *Spider) Result {
+	if !dm.enabled || request.Method == http.MethodHead || response.BodyLen() == 0 {
+		return response
+	}
+	encodings := parseContentEncoding(response.Headers.Values("Content-Encoding"))
+	if len(encodings) == 0 {
+		return response
+	}
+
+	maxSize := container.GetWithDefault[int](request.Ctx, "download_maxsize", dm.maxSize)
+	warnSize := container.GetWithDefault[int](request.Ctx, "download_warnsize", dm.warnSize)
+	// 按与压缩相反的顺序逐层解压，遇到不支持的编码或解压失败时停止并保留剩余的编码
+	i := len(encodings) - 1
+	for ; i >= 0; i-- {
+		var err error
+		if response.BodyFile != "" {
+			var n int64
+			if n, err = decompressFile(encodings[i], response.BodyFile, maxSize); err == nil {
+				response.BodySize = int(n)
+			}
+		} else {
+			var decoded []byte
+			if decoded, err = decompress(encodings[i], response.Body, maxSize); err == nil {
+				response.Body = decoded
+			}
+		}
+		if errors.Is(err, errUnsupportedEncoding) {
+			break
+		}
+		var sizeErr *DownloadSizeError
+		if errors.As(err, &sizeErr) {
+			sizeErr.Url = request.Url.String()
+			dm.Stats.IncValue("httpcompression/max_size_exceeded", 1, 0)
+			ResponseLogger(dm.Logger, response).Errorw("解压后的Response超过最大限制",
+				"encoding", encodings[i], "download_maxsize", maxSize)
+			panic(sizeErr)
+		}
+		if err != nil {
+			ResponseLogger(dm.Logger, response).Warnw("Response解压失败", "encoding", encodings[i], "error", err)
+			break
+		}
+		dm.Stats.IncValue("httpcompression/encoding_count/"+encodings[i], 1, 0)
+	}
+	if i == len(encodings)-1 {
+		return response
+	}
+	if i >= 0 {
+		response.Headers.Set("Content-Encoding", strings.Join(encodings[:i+1], ", "))
+	} else {
+		response.Headers.Del("Content-Encoding")
+	}
+
+	size := response.BodyLen()
+	if warnSize > 0 && size > warnSize {
+		ResponseLogger(dm.Logger, response).Warnw("解压后的Response超过警告大小",
+			"size", size, "download_warnsize", warnSize)
+	}
+	dm.Stats.IncValue("httpcompression/response_bytes", size, 0)
+	dm.Stats.IncValue("httpcompression/response_count", 1, 0)
+	return response
+}
+
+// HttpProxyDownloaderMiddleware 为Request设置代理，代理来自PROXY_LIST、PROXY_LIST_FILE组成的代理池，
+// 代理池为空时使用环境变量HTTP_PROXY、HTTPS_PROXY、NO_PROXY。Ctx中设置了proxy时使用指定的代理，为空字符串时不使用代理。
+// 代理的认证信息以Proxy-Authorization请求头传给下载器，该请求头不会被序列化，每次下载前根据代理重新设置
+type HttpProxyDownloaderMiddleware struct {
+	BaseDownloaderMiddleware
+	enabled     bool
+	fromEnv     bool
+	pool        *proxyPool
+	maxFailures int
+	banCodes    []int
+	banTime     time.Duration
+}
+
+func (dm *HttpProxyDownloaderMiddleware) Name() string {
+	return "HttpProxyDownloaderMiddleware"
+}
+
+func (dm *HttpProxyDownloaderMiddleware) FromSpider(spider *Spider) {
+	InitBaseSpiderModule(&dm.BaseSpiderModule, spider, dm.Name())
+	dm.enabled = container.GetWithDefault[bool](spider.Settings, "HTTPPROXY_ENABLED", true)
+	dm.fromEnv = container.GetWithDefault[bool](spider.Settings, "HTTPPROXY_FROM_ENV", true)
+	dm.maxFailures = container.GetWithDefault[int](spider.Settings, "PROXY_MAX_FAILURES", 3)
+	dm.banCodes = container.GetWithDefault[[]int](spider.Settings, "PROXY_BAN_CODES", []int{403, 407, 429})
+	banTime := container.GetWithDefault[int](spider.Settings, "PROXY_BAN_TIME", 300)
+	dm.banTime = time.Duration(banTime) * time.Second
+
+	proxies := container.GetWithDefault[[]string](spider.Settings, "PROXY_LIST", []string{})
+	if file := container.GetWithDefault[string](spider.Settings, "PROXY_LIST_FILE", ""); file != "" {
+		list, err := readProxyFile(file)
+		if err != nil {
+			dm.Logger.Fatalw("读取代理列表文件失败", "file", file, "error", err)
+		}
+		proxies = append(proxies, list...)
+	}
+	mode := container.GetWithDefault[string](spider.Settings, "PROXY_MODE", ProxyModeRoundRobin)
+	pool, err := newProxyPool(proxies, mode)
+	if err != nil {
+		dm.Logger.Fatalw("代理池初始化失败", "error", err)
+	}
+	dm.pool = pool
+	if pool.Len() > 0 {
+		dm.Logger.Infow("代理池初始化完成", "proxies", pool.Len(), "mode", mode)
+	}
+}
+
+// poolProxy 获取Request使用的代理池中的代理，未使用代理池时返回nil
+func (dm *HttpProxyDownloaderMiddleware) poolProxy(request *Request) *proxyState {
+	proxy := container.GetWithDefault[string](request.Ctx, "pool_proxy", "")
+	if proxy == "" || proxy != container.GetWithDefault[string](request.Ctx, "proxy", "") {
+		return nil
+	}
+	return dm.pool.Find(proxy)
+}
+
+// envProxy Request使用的代理是否来自环境变量
+func (dm *HttpProxyDownloaderMiddleware) envProxy(request *Request) bool {
+	proxy := container.GetWithDefault[string](request.Ctx, "env_proxy", "")
+	return proxy != "" && proxy == container.GetWithDefault[string](request.Ctx, "proxy", "")
+}
+
+// proxyStatsKey 单个代理的统计项
+func proxyStatsKey(proxy string, name string) string {
+	if u, err := url.Parse(proxy); err == nil {
+		proxy = u.Host
+	}
+	return fmt.Sprintf("httpproxy/proxy/%s/%s", proxy, name)
+}
+
+func (dm *HttpProxyDownloaderMiddleware) ProcessRequest(request *Request, spider *Spider) Result {
+	if !dm.enabled {
+		return nil
+	}
+
+	// 重试或从磁盘恢复的Request重新从代理池或环境变量中选择代理
+	if dm.poolProxy(request) != nil || dm.envProxy(request) {
+		request.Ctx.Delete("proxy")
+		request.Ctx.Delete("env_proxy")
+	}
+
+	if request.Ctx.Has("proxy") {
+		proxy := container.GetWithDefault[string](request.Ctx, "proxy", "")
+		if proxy == "" {
+			request.Headers.Del("Proxy-Authorization")
+			return nil
+		}
+		// 保留Ctx中代理地址的认证信息，Proxy-Authorization未被序列化，恢复后仍可重新设置
+		_, auth, err := parseProxy(proxy)
+		if err != nil {
+			panic(err)
+		}
+		if auth != "" {
+			request.Headers.Set("Proxy-Authorization", auth)
+		} else {
+			request.Headers.Del("Proxy-Authorization")
+		}
+		return nil
+	}
+
+	if p := dm.pool.Get(); p != nil {
+		container.Set(request.Ctx, "proxy", p.url)
+		container.Set(request.Ctx, "pool_proxy", p.url)
+		if p.auth != "" {
+			request.Headers.Set("Proxy-Authorization", p.auth)
+		} else {
+			request.Headers.Del("Proxy-Authorization")
+		}
+		dm.Stats.IncValue(proxyStatsKey(p.url, "request_count"), 1, 0)
+		return nil
+	}
+
+	if dm.fromEnv {
+		u, err := http.ProxyFromEnvironment(&http.Request{URL: request.Url})
+		if err != nil {
+			panic(err)
+		}
+		if u != nil {
+			proxy, auth, err := parseProxy(u.String())
+			if err != nil {
+				panic(err)
+			}
+			container.Set(request.Ctx, "proxy", proxy)
+			container.Set(request.Ctx, "env_proxy", proxy)
+			if auth != "" {
+				request.Headers.Set("Proxy-Authorization", auth)
+			}
+		}
+	}
+	return nil
+}
+
+func (dm *HttpProxyDownloaderMiddleware) ProcessResponse(request *Request, response *Response, spider *Spider) Result {
+	p := dm.poolProxy(request)
+	if !dm.enabled || p == nil {
+		return response
+	}
+
+	if slices.Contains(dm.banCodes, response.StatusCode) {
+		dm.pool.Ban(p, dm.banTime)
+		dm.Stats.IncValue(proxyStatsKey(p.url, "ban_count"), 1, 0)
+		ResponseLogger(dm.Logger, response).Warnw("代理被封禁，暂停使用",
+			"proxy", redactProxy(p.url), "ban_time", dm.banTime.String())
+		return response
+	}
+	dm.pool.Success(p)
+	dm.Stats.IncValue(proxyStatsKey(p.url, "response_count"), 1, 0)
+	return response
+}
+
+func (dm *HttpProxyDownloaderMiddleware) ProcessError(request *Request, err error, spider *Spider) Result {
+	p := dm.poolProxy(request)
+	if !dm.enabled || p == nil {
+		return nil
+	}
+
+	reason := ErrorToReason(err)
+	dm.Stats.IncValue(proxyStatsKey(p.url, "failure_count/"+reason), 1, 0)
+	if dm.pool.Failure(p, dm.maxFailures, dm.banTime) {
+		dm.Stats.IncValue(proxyStatsKey(p.url, "ban_count"), 1, 0)
+		RequestLogger(dm.Logger, request).Warnw("代理连续失败次数过多，暂停使用",
+			"proxy", redactProxy(p.url), "reason", reason, "ban_time", dm.banTime.String())
+	}
+	// 清除失败的代理，重试时重新选择
+	request.Ctx.Delete("proxy")
+	request.Ctx.Delete("pool_proxy")
+	request.Headers.Del("Proxy-Authorization")
+	return nil
+}
+
+// robotsTxtEntry 单个站点的robots.txt，ready为true后data可用，之前到达的Request保存在waiting中
+type robotsTxtEntry struct {
+	data    *robotstxt.RobotsData
+	ready   bool
+	waiting []*Request
+}
+
+// RobotsTxtDownloaderMiddleware ROBOTSTXT_OBEY为true时按站点的robots.txt过滤Request，
+// robots.txt通过Engine下载，与普通Request一样经过代理、slot等处理，解析结果按站点缓存。
+// robots.txt下载完成前到达的Request暂缓处理并占用下载slot的排队位置，下载完成或超时后重新经过下载器中间件。
+// Ctx中设置了dont_obey_robotstxt时不检查
+type RobotsTxtDownloaderMiddleware struct {
+	BaseDownloaderMiddleware
+	enabled   bool
+	userAgent string
+	timeout   time.Duration
+	entries   map[string]*robotsTxtEntry
+	mu        sync.Mutex
+}
+
+func (dm *RobotsTxtDownloaderMiddleware) Name() string {
+	return "RobotsTxtDownloaderMiddleware"
+}
+
+func (dm *RobotsTxtDownloaderMiddleware) FromSpider(spider *Spider) {
+	InitBaseSpiderModule(&dm.BaseSpiderModule, spider, dm.Name())
+	dm.enabled = container.GetWithDefault[bool](spider.Settings, "ROBOTSTXT_OBEY", false)
+	// 匹配robots.txt中的User-agent时只使用开头的产品名，如xbot/1.0匹配User-agent: xbot
+	dm.userAgent = container.GetWithDefault[string](spider.Settings, "ROBOTSTXT_USER_AGENT", "")
+	if dm.userAgent == "" {
+		dm.userAgent = container.GetWithDefault[string](spider.Settings, "USER_AGENT", "")
+	}
+	// 等待robots.txt的最长时间，覆盖下载超时和重试
+	timeout := container.GetWithDefault[int](spider.Settings, "DOWNLOAD_TIMEOUT", 180)
+	retryTimes := container.GetWithDefault[int](spider.Settings, "RETRY_TIMES", 2)
+	dm.timeout = time.Duration(timeout*(retryTimes+1)) * time.Second
+	dm.entries = make(map[string]*robotsTxtEntry)
+
+	if dm.enabled {
+		// 在Engine之前截获robots.txt的处理结果，避免交给Spider解析
+		spider.Signal.Connect(dm.robotsTxtResponse, StResponseLeftDownloaderMiddleware, 400)
+		spider.Signal.Connect(dm.robotsTxtErrback, StRequestErrback, 400)
+		spider.Signal.Connect(dm.robotsTxtError, StErrorUnhandled, 400)
+		spider.Signal.Connect(dm.robotsTxtDropped, StRequestDropped, 400)
+	}
+}
+
+func robotsTxtNetloc(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// robots 获取Request所属站点的robots.txt，首次访问时通过Engine下载。
+// robots.txt尚未下载完成时保存Request并返回false，Request由setRobots重新发出
+func (dm *RobotsTxtDownloaderMiddleware) robots(request *Request, spider *Spider) (*robotstxt.RobotsData, bool) {
+	netloc := robotsTxtNetloc(request.Url)
+
+	dm.mu.Lock()
+	entry, ok := dm.entries[netloc]
+	if ok && entry.ready {
+		dm.mu.Unlock()
+		return entry.data, true
+	}
+	if !ok {
+		entry = &robotsTxtEntry{}
+		dm.entries[netloc] = entry
+	}
+	entry.waiting = append(entry.waiting, request)
+	spider.requestSlot.Reserve(request)
+	dm.mu.Unlock()
+
+	if !ok {
+		robotsRequest := NewRequest(netloc+"/robots.txt", WithDontFilter(true), WithPriority(request.Priority))
+		container.Set(robotsRequest.Ctx, "robotstxt_netloc", netloc)
+		// 与原Request使用同一个slot
+		for _, key := range []string{"domain", "download_slot"} {
+			if value, err := container.Get[string](request.Ctx, key); err == nil {
+				container.Set(robotsRequest.Ctx, key, value)
+			}
+		}
+		dm.Stats.IncValue("robotstxt/request_count", 1, 0)
+		RequestLogger(dm.Logger, robotsRequest).Debug("开始下载robots.txt")
+		spider.Signal.Emit(NewRequestReachedDownloaderMiddlewareSignal(SenderEngine, robotsRequest, spider))
+		time.AfterFunc(dm.timeout, func() {
+			if dm.setRobots(netloc, nil, robotstxt.AllowAll(), spider) {
+				dm.Logger.Warnw("等待robots.txt超时，允许访问该站点的所有页面", "netloc", netloc)
+			}
+		})
+	}
+	return nil, false
+}
+
+// setRobots 保存站点的robots.txt，并重新发出等待中的Request，robots.txt已保存时返回false
+func (dm *RobotsTxtDownloaderMiddleware) setRobots(netloc string, request *Request, data *robotstxt.RobotsData, spider *Spider) bool {
+	dm.mu.Lock()
+	entry, ok := dm.entries[netloc]
+	if !ok || entry.ready {
+		dm.mu.Unlock()
+		return false
+	}
+	entry.data = data
+	entry.ready = true
+	waiting := entry.waiting
+	entry.waiting = nil
+	dm.mu.Unlock()
+
+	if delay, ok := data.CrawlDelay(dm.userAgent); ok && request != nil {
+		spider.Signal.Emit(NewRobotsTxtCrawlDelaySignal(SenderProcessResponse, request, delay, spider))
+	}
+	// 先发出信号再释放排队位置，避免期间被判定为空闲
+	for _, r := range waiting {
+		spider.Signal.Emit(NewRequestReachedDownloaderMiddlewareSignal(SenderEngine, r, spider))
+		spider.requestSlot.Unreserve(r)
+	}
+	return true
+}
+
+func (dm *RobotsTxtDownloaderMiddleware) robotsTxtResponse(response *Response, spider *Spider) {
+	if !response.Request.Ctx.Has("robotstxt_netloc") {
+		return
+	}
+	dm.Stats.IncValue("robotstxt/response_count", 1, 0)
+	dm.Stats.IncValue(fmt.Sprintf("robotstxt/response_status_count/%d", response.StatusCode), 1, 0)
+	netloc := container.GetWithDefault[string](response.Request.Ctx, "robotstxt_netloc", "")
+	dm.setRobots(netloc, response.Request, robotstxt.FromStatusAndBytes(response.StatusCode, response.Body), spider)
+	panic(ErrDropSignal)
+}
+
+// robotsTxtFailed 下载robots.txt失败，服务器返回了5xx等错误响应时按响应状态码处理，否则允许访问所有页面
+func (dm *RobotsTxtDownloaderMiddleware) robotsTxtFailed(request *Request, response *Response, err error, spider *Spider) {
+	netloc := container.GetWithDefault[string](request.Ctx, "robotstxt_netloc", "")
+	if response != nil {
+		if dm.setRobots(netloc, request, robotstxt.FromStatusAndBytes(response.StatusCode, response.Body), spider) {
+			dm.Stats.IncValue(fmt.Sprintf("robotstxt/response_status_count/%d", response.StatusCode), 1, 0)
+		}
+		return
+	}
+	reason := ErrorToReason(err)
+	if dm.setRobots(netloc, request, robotstxt.AllowAll(), spider) {
+		dm.Stats.IncValue("robotstxt/exception_count/"+reason, 1, 0)
+		RequestLogger(dm.Logger, request).Warnw("下载robots.txt失败，允许访问该站点的所有页面", "reason", reason, "error", err)
+	}
+}
+
+func (dm *RobotsTxtDownloaderMiddleware) robotsTxtErrback(request *Request, response *Response, err error, spider *Spider) {
+	if !request.Ctx.Has("robotstxt_netloc") {
+		return
+	}
+	dm.robotsTxtFailed(request, response, err, spider)
+	panic(ErrDropSignal)
+}
+
+func (dm *RobotsTxtDownloaderMiddleware) robotsTxtError(request *Request, response *Response, err error, spider *Spider) {
+	if request == nil || !request.Ctx.Has("robotstxt_netloc") {
+		return
+	}
+	dm.robotsTxtFailed(request, response, err, spider)
+}
+
+// robotsTxtDropped robots.txt的Request被其他模块丢弃时不会再有结果，按下载失败处理
+func (dm *RobotsTxtDownloaderMiddleware) robotsTxtDropped(request *Request, err error, spider *Spider) {
+	if !request.Ctx.Has("robotstxt_netloc") {
+		return
+	}
+	dm.robotsTxtFailed(request, nil, err, spider)
+}
+
+func (dm *RobotsTxtDownloaderMiddleware) ProcessRequest(request *Request, spider *Spider) Result {
+	if !dm.enabled || request.Ctx.Has("robotstxt_netloc") ||
+		container.GetWithDefault[bool](request.Ctx, "dont_obey_robotstxt", false) {
+		return nil
+	}
+
+	data, ok := dm.robots(request, spider)
+	if !ok {
+		panic(ErrRequestDeferred)
+	}
+	if !data.TestAgent(request.Url.String(), dm.userAgent) {
+		dm.Stats.IncValue("robotstxt/forbidden", 1, 0)
+		panic(fmt.Errorf("%s: %w", request.Url.String(), ErrRobotsTxtForbidden))
+	}
+	return nil
+}
+
+// HttpCacheDownloaderMiddleware HTTPCACHE_ENABLED为true时缓存Response，命中缓存时不再下载，
+// 存储方式和缓存策略分别由HTTPCACHE_STORAGE_STRUCT、HTTPCACHE_POLICY_STRUCT指定。
+// Ctx中设置了dont_cache时不使用缓存，使用缓存的Response的Ctx中cached为true
+type HttpCacheDownloaderMiddleware struct {
+	BaseDownloaderMiddleware
+	enabled       bool
+	ignoreMissing bool
+	storage       HttpCacheStorager
+	policy        HttpCachePolicier
+	// 需要验证的缓存，等待下载结果
+	validating map[*Request]*cacheValidation
+	mu         sync.Mutex
+}
+
+// cacheValidation 等待验证的缓存及验证前的请求头，验证结束后恢复请求头，避免条件请求头被带入重定向、重试等复制的Request
+type cacheValidation struct {
+	cached  *Response
+	headers http.Header
+}
+
+func (dm *HttpCacheDownloaderMiddleware) Name() string {
+	return "HttpCacheDownloaderMiddleware"
+}
+
+func (dm *HttpCacheDownloaderMiddleware) FromSpider(spider *Spider) {
+	InitBaseSpiderModule(&dm.BaseSpiderModule, spider, dm.Name())
+	dm.enabled = container.GetWithDefault[bool](spider.Settings, "HTTPCACHE_ENABLED", false)
+	dm.ignoreMissing = container.GetWithDefault[bool](spider.Settings, "HTTPCACHE_IGNORE_MISSING", false)
+	dm.validating = make(map[*Request]*cacheValidation)
+	if !dm.enabled {
+		return
+	}
+	// 被丢弃的Request不会再有下载结果
+	spider.Signal.Connect(dm.requestDropped, StRequestDropped, 400)
+
+	storageStr := container.GetWithDefault[string](spider.Settings, "HTTPCACHE_STORAGE_STRUCT", "FilesystemHttpCacheStorage")
+	dm.storage = GetAndAssertComponent[HttpCacheStorager](storageStr)
+	dm.storage.FromSpider(spider)
+	policyStr := container.GetWithDefault[string](spider.Settings, "HTTPCACHE_POLICY_STRUCT", "DummyHttpCachePolicy")
+	dm.policy = GetAndAssertComponent[HttpCachePolicier](policyStr)
+	dm.policy.FromSpider(spider)
+}
+
+func (dm *HttpCacheDownloaderMiddleware) skip(request *Request) bool {
+	return !dm.enabled ||
+		container.GetWithDefault[bool](request.Ctx, "dont_cache", false) ||
+		!dm.policy.ShouldCacheRequest(request)
+}
+
+// popValidating 取出Request等待验证的缓存，并恢复缓存策略修改的请求头
+func (dm *HttpCacheDownloaderMiddleware) popValidating(request *Request) *Response {
+	dm.mu.Lock()
+	v, ok := dm.validating[request]
+	delete(dm.validating, request)
+	dm.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	for key, values := range v.headers {
+		if values == nil {
+			request.Headers.Del(key)
+		} else {
+			(*request.Headers)[key] = values
+		}
+	}
+	return v.cached
+}
+
+func (dm *HttpCacheDownloaderMiddleware) requestDropped(request *Request, err error, spider *Spider) {
+	dm.popValidating(request)
+}
+
+func (dm *HttpCacheDownloaderMiddleware) ProcessRequest(request *Request, spider *Spider) Result {
+	// 重定向等复制的Request可能带有上一次的标记
+	request.Ctx.Delete("cached")
+	if dm.skip(request) {
+		return nil
+	}
+
+	cached := dm.storage.RetrieveResponse(request)
+	if cached == nil {
+		dm.Stats.IncValue("httpcache/miss", 1, 0)
+		if dm.ignoreMissing {
+			dm.Stats.IncValue("httpcache/ignore", 1, 0)
+			panic(fmt.Errorf("%s: %w", request.Url.String(), ErrHttpCacheMissing))
+		}
+		return nil
+	}
+
+	before := request.Headers.Clone()
+	if dm.policy.IsCachedResponseFresh(cached, request) {
+		dm.Stats.IncValue("httpcache/hit", 1, 0)
+		container.Set(request.Ctx, "cached", true)
+		return cached
+	}
+
+	// 缓存已过期，下载后再判断缓存是否仍然有效，记录被修改的请求头验证前的值
+	changed := make(http.Header)
+	for key, values := range *request.Headers {
+		if !slices.Equal(values, before[key]) {
+			changed[key] = before[key]
+		}
+	}
+	for key, values := range before {
+		if _, ok := (*request.Headers)[key]; !ok {
+			changed[key] = values
+		}
+	}
+	dm.mu.Lock()
+	dm.validating[request] = &cacheValidation{cached: cached, headers: changed}
+	dm.mu.Unlock()
+	return nil
+}
+
+func (dm *HttpCacheDownloaderMiddleware) ProcessResponse(request *Request, response *Response, spider *Spider) Result {
+	if !dm.enabled {
+		return response
+	}
+	cached := dm.popValidating(request)
+	if container.GetWithDefault[bool](request.Ctx, "cached", false) || dm.skip(request) {
+		return response
+	}
+
+	if cached != nil {
+		if dm.policy.IsCachedResponseValid(cached, response, request) {
+			dm.Stats.IncValue("httpcache/revalidate", 1, 0)
+			container.Set(request.Ctx, "cached", true)
+			return cached
+		}
+		dm.Stats.IncValue("httpcache/invalidate", 1, 0)
+	}
+
+	if dm.policy.ShouldCacheResponse(response, request) {
+		dm.Stats.IncValue("httpcache/store", 1, 0)
+		dm.storage.StoreResponse(request, response)
+	} else {
+		dm.Stats.IncValue("httpcache/uncacheable", 1, 0)
+	}
+	return response
+}
+
+func (dm *HttpCacheDownloaderMiddleware) ProcessError(request *Request, err error, spider *Spider) Result {
+	if !dm.enabled {
+		return nil
+	}
+	// 下载失败时使用过期的缓存
+	cached := dm.popValidating(request)
+	if cached != nil && !errors.Is(err, ErrDropRequest) {
+		dm.Stats.IncValue("httpcache/errorrecovery", 1, 0)
+		container.Set(request.Ctx, "cached", true)
+		return cached
+	}
+	return nil
+}
+
+func (dm *HttpCacheDownloaderMiddleware) Close(spider *Spider) {
+	if dm.enabled {
+		dm.storage.Close(spider)
+		dm.policy.Close(spider)
+	}
+	dm.BaseDownloaderMiddleware.Close(spider)
+}
+
 type DownloaderStatsDownloaderMiddleware struct {
 	BaseDownloaderMiddleware
 }
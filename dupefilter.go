@@ -0,0 +1,502 @@
+package xspider
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xue0228/xspider/bloom"
+	"github.com/xue0228/xspider/container"
+)
+
+func init() {
+	RegisterSpiderModuler(&BloomDupeFilter{})
+	RegisterSpiderModuler(&BinaryDupeFilter{})
+	RegisterSpiderModuler(&TTLDupeFilter{})
+}
+
+// fingerprintKey 将十六进制的SHA1指纹转换为20字节，其他格式的指纹使用其SHA1值
+func fingerprintKey(fp string) [sha1.Size]byte {
+	var key [sha1.Size]byte
+	if len(fp) == hex.EncodedLen(sha1.Size) {
+		if _, err := hex.Decode(key[:], []byte(fp)); err == nil {
+			return key
+		}
+	}
+	return sha1.Sum([]byte(fp))
+}
+
+// BloomDupeFilter 使用可自动扩容的布隆过滤器去重，内存占用远小于DupeFilterImpl，但存在一定误判率，误判的Request将被滤除。
+// 第一个过滤器的容量由DUPE_FILTER_BLOOM_CAPACITY设置，整体误判率由DUPE_FILTER_BLOOM_ERROR_RATE设置。
+// 设置了JOBDIR时过滤器在Flush和Close时保存到JOBDIR/requests.bloom中，再次运行时恢复
+type BloomDupeFilter struct {
+	BaseSpiderModule
+	fingerprinter RequestFingerprinter
+	filter        *bloom.ScalableFilter
+	path          string
+	mu            sync.Mutex
+}
+
+func (d *BloomDupeFilter) Name() string {
+	return "BloomDupeFilter"
+}
+
+func (d *BloomDupeFilter) FromSpider(spider *Spider) {
+	InitBaseSpiderModule(&d.BaseSpiderModule, spider, d.Name())
+	d.fingerprinter = spider.Fingerprinter
+	capacity := container.GetWithDefault[int](spider.Settings, "DUPE_FILTER_BLOOM_CAPACITY", 1000000)
+	errorRate := container.GetWithDefault[float64](spider.Settings, "DUPE_FILTER_BLOOM_ERROR_RATE", 0.001)
+	d.filter = bloom.New(uint64(max(capacity, 1)), errorRate)
+
+	if jobdir := container.GetWithDefault[string](spider.Settings, "JOBDIR", ""); jobdir != "" {
+		d.path = filepath.Join(jobdir, "requests.bloom")
+		if err := d.load(); err != nil {
+			d.Logger.Fatalw("读取布隆过滤器失败", "path", d.path, "error", err)
+		}
+		d.Logger.Infow("从磁盘恢复布隆过滤器", "path", d.path, "count", d.filter.Count())
+	}
+	d.updateStats()
+	d.Logger.Infow("模块初始化完成", "capacity", capacity, "error_rate", errorRate)
+}
+
+func (d *BloomDupeFilter) load() error {
+	f, err := os.Open(d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = d.filter.ReadFrom(f)
+	return err
+}
+
+func (d *BloomDupeFilter) RequestSeen(request *Request) bool {
+	key := fingerprintKey(d.RequestFingerprint(request))
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.filter.TestAndAdd(key[:]) {
+		return true
+	}
+	d.updateStats()
+	return false
+}
+
+// updateStats 记录指纹数量及占用的内存
+func (d *BloomDupeFilter) updateStats() {
+	d.Stats.SetValue("dupe_filter/fingerprints", int(d.filter.Count()))
+	d.Stats.SetValue("dupe_filter/memory_usage", d.filter.MemoryUsage())
+}
+
+// Flush 将过滤器写入临时文件后替换原文件，避免写入中断导致文件损坏
+func (d *BloomDupeFilter) Flush() error {
+	if d.path == "" {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(d.path), 0755); err != nil {
+		return err
+	}
+	tmp := d.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = d.filter.WriteTo(f); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.path)
+}
+
+func (d *BloomDupeFilter) Close(spider *Spider) {
+	if err := d.Flush(); err != nil {
+		d.Logger.Errorw("布隆过滤器写入磁盘失败", "path", d.path, "error", err)
+	}
+	d.BaseSpiderModule.Close(spider)
+}
+
+func (d *BloomDupeFilter) RequestFingerprint(request *Request) string {
+	return d.fingerprinter.Fingerprint(request)
+}
+
+func (d *BloomDupeFilter) Log(request *Request) {
+	RequestLogger(d.Logger, request).Debug("Request已滤除")
+	d.Stats.IncValue("dupe_filter/filtered", 1, 0)
+}
+
+// binaryFingerprintSize 每个指纹在map中大约占用的字节数（20字节指纹及map的额外开销）
+const binaryFingerprintSize = 24
+
+// BinaryDupeFilter 以20字节的二进制形式保存指纹，没有误判，内存占用约为DupeFilterImpl的五分之一。
+// 设置了JOBDIR时将指纹追加保存在JOBDIR/requests.seen.bin中，再次运行时恢复
+type BinaryDupeFilter struct {
+	BaseSpiderModule
+	fingerprinter RequestFingerprinter
+	fingerprints  map[[sha1.Size]byte]struct{}
+	file          *os.File
+	writer        *bufio.Writer
+	mu            sync.Mutex
+}
+
+func (d *BinaryDupeFilter) Name() string {
+	return "BinaryDupeFilter"
+}
+
+func (d *BinaryDupeFilter) FromSpider(spider *Spider) {
+	InitBaseSpiderModule(&d.BaseSpiderModule, spider, d.Name())
+	d.fingerprinter = spider.Fingerprinter
+	d.fingerprints = make(map[[sha1.Size]byte]struct{})
+	if jobdir := container.GetWithDefault[string](spider.Settings, "JOBDIR", ""); jobdir != "" {
+		if err := d.open(filepath.Join(jobdir, "requests.seen.bin")); err != nil {
+			d.Logger.Fatalw("打开指纹文件失败", "jobdir", jobdir, "error", err)
+		}
+		d.Logger.Infow("从磁盘恢复指纹", "jobdir", jobdir, "count", len(d.fingerprints))
+	}
+	d.updateStats()
+	d.Logger.Info("模块初始化完成")
+}
+
+// open 读取已保存的指纹，文件末尾不完整的指纹将被丢弃，之后新增的指纹追加到文件末尾
+func (d *BinaryDupeFilter) open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(f)
+	var key [sha1.Size]byte
+	var size int64
+	for {
+		if _, err = io.ReadFull(reader, key[:]); err != nil {
+			break
+		}
+		d.fingerprints[key] = struct{}{}
+		size += sha1.Size
+	}
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Truncate(size); err == nil {
+		_, err = f.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	d.file = f
+	d.writer = bufio.NewWriter(f)
+	return nil
+}
+
+func (d *BinaryDupeFilter) RequestSeen(request *Request) bool {
+	key := fingerprintKey(d.RequestFingerprint(request))
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.fingerprints[key]; ok {
+		return true
+	}
+	d.fingerprints[key] = struct{}{}
+	if d.writer != nil {
+		if _, err := d.writer.Write(key[:]); err != nil {
+			RequestLogger(d.Logger, request).Errorw("指纹写入磁盘失败", "error", err)
+		}
+	}
+	d.updateStats()
+	return false
+}
+
+// updateStats 记录指纹数量及估算的内存占用
+func (d *BinaryDupeFilter) updateStats() {
+	d.Stats.SetValue("dupe_filter/fingerprints", len(d.fingerprints))
+	d.Stats.SetValue("dupe_filter/memory_usage", len(d.fingerprints)*binaryFingerprintSize)
+}
+
+func (d *BinaryDupeFilter) Flush() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.writer == nil {
+		return nil
+	}
+	if err := d.writer.Flush(); err != nil {
+		return err
+	}
+	return d.file.Sync()
+}
+
+func (d *BinaryDupeFilter) Close(spider *Spider) {
+	if err := d.Flush(); err != nil {
+		d.Logger.Errorw("指纹写入磁盘失败", "error", err)
+	}
+	if d.file != nil {
+		_ = d.file.Close()
+	}
+	d.BaseSpiderModule.Close(spider)
+}
+
+func (d *BinaryDupeFilter) RequestFingerprint(request *Request) string {
+	return d.fingerprinter.Fingerprint(request)
+}
+
+func (d *BinaryDupeFilter) Log(request *Request) {
+	RequestLogger(d.Logger, request).Debug("Request已滤除")
+	d.Stats.IncValue("dupe_filter/filtered", 1, 0)
+}
+
+// ttlEntry 指纹最后一次通过去重的时间及当时使用的有效期，单位为秒
+type ttlEntry struct {
+	seen int64
+	ttl  int64
+}
+
+const (
+	// ttlRecordSize 磁盘中每条记录的大小：20字节指纹、8字节时间、8字节有效期
+	ttlRecordSize = sha1.Size + 16
+	// ttlEntrySize 每个指纹在map中大约占用的字节数
+	ttlEntrySize = 40
+)
+
+// TTLDupeFilter 指纹只在有效期内视为重复，过期后Request可以再次通过去重，用于定期重新爬取。
+// 有效期由DUPEFILTER_TTL设置（单位为秒，小于等于0时永不过期），Request的Ctx中设置了dupefilter_ttl时优先使用。
+// 过期的指纹每隔DUPEFILTER_TTL_PURGE_INTERVAL秒清理一次。
+// 指纹追加保存在DUPEFILTER_TTL_PATH中，未设置时使用JOBDIR/requests.seen.ttl，打开和关闭时删除过期的记录
+type TTLDupeFilter struct {
+	BaseSpiderModule
+	fingerprinter RequestFingerprinter
+	ttl           int64
+	purgeInterval int64
+	lastPurge     int64
+	entries       map[[sha1.Size]byte]ttlEntry
+	path          string
+	file          *os.File
+	writer        *bufio.Writer
+	mu            sync.Mutex
+}
+
+func (d *TTLDupeFilter) Name() string {
+	return "TTLDupeFilter"
+}
+
+func (d *TTLDupeFilter) FromSpider(spider *Spider) {
+	InitBaseSpiderModule(&d.BaseSpiderModule, spider, d.Name())
+	d.fingerprinter = spider.Fingerprinter
+	d.ttl = int64(container.GetWithDefault[int](spider.Settings, "DUPEFILTER_TTL", 0))
+	d.purgeInterval = int64(container.GetWithDefault[int](spider.Settings, "DUPEFILTER_TTL_PURGE_INTERVAL", 3600))
+	d.entries = make(map[[sha1.Size]byte]ttlEntry)
+	d.lastPurge = time.Now().Unix()
+
+	d.path = container.GetWithDefault[string](spider.Settings, "DUPEFILTER_TTL_PATH", "")
+	if jobdir := container.GetWithDefault[string](spider.Settings, "JOBDIR", ""); d.path == "" && jobdir != "" {
+		d.path = filepath.Join(jobdir, "requests.seen.ttl")
+	}
+	if d.path != "" {
+		if err := d.open(); err != nil {
+			d.Logger.Fatalw("打开指纹文件失败", "path", d.path, "error", err)
+		}
+		d.Logger.Infow("从磁盘恢复指纹", "path", d.path, "count", len(d.entries))
+	}
+	d.updateStats()
+	d.Logger.Infow("模块初始化完成", "ttl", d.ttl)
+}
+
+// open 读取已保存的指纹并删除过期的记录，之后新增的指纹追加到文件末尾
+func (d *TTLDupeFilter) open() error {
+	if err := d.load(); err != nil {
+		return err
+	}
+	d.purge(time.Now().Unix())
+	if err := d.rewrite(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(d.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	d.file = f
+	d.writer = bufio.NewWriter(f)
+	return nil
+}
+
+// load 读取指纹文件，同一指纹以最后一条记录为准，文件末尾不完整的记录将被丢弃
+func (d *TTLDupeFilter) load() error {
+	if err := os.MkdirAll(filepath.Dir(d.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Open(d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	record := make([]byte, ttlRecordSize)
+	for {
+		if _, err = io.ReadFull(reader, record); err != nil {
+			break
+		}
+		var key [sha1.Size]byte
+		copy(key[:], record)
+		d.entries[key] = ttlEntry{
+			seen: int64(binary.BigEndian.Uint64(record[sha1.Size:])),
+			ttl:  int64(binary.BigEndian.Uint64(record[sha1.Size+8:])),
+		}
+	}
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	return nil
+}
+
+// rewrite 将内存中的指纹写入临时文件后替换原文件
+func (d *TTLDupeFilter) rewrite() error {
+	tmp := d.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(f)
+	for key, entry := range d.entries {
+		if _, err = writer.Write(ttlRecord(key, entry)); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.path)
+}
+
+func ttlRecord(key [sha1.Size]byte, entry ttlEntry) []byte {
+	record := make([]byte, ttlRecordSize)
+	copy(record, key[:])
+	binary.BigEndian.PutUint64(record[sha1.Size:], uint64(entry.seen))
+	binary.BigEndian.PutUint64(record[sha1.Size+8:], uint64(entry.ttl))
+	return record
+}
+
+// requestTTL Request使用的有效期
+func (d *TTLDupeFilter) requestTTL(request *Request) int64 {
+	if ttl, err := container.Get[int](request.Ctx, "dupefilter_ttl"); err == nil {
+		return int64(ttl)
+	}
+	return d.ttl
+}
+
+// purge 删除已过期的指纹，按记录时使用的有效期判断
+func (d *TTLDupeFilter) purge(now int64) {
+	d.lastPurge = now
+	count := 0
+	for key, entry := range d.entries {
+		if entry.ttl > 0 && now-entry.seen >= entry.ttl {
+			delete(d.entries, key)
+			count++
+		}
+	}
+	if count > 0 {
+		d.Stats.IncValue("dupe_filter/expired", count, 0)
+		d.Logger.Debugw("已清理过期的指纹", "count", count)
+	}
+}
+
+func (d *TTLDupeFilter) RequestSeen(request *Request) bool {
+	key := fingerprintKey(d.RequestFingerprint(request))
+	ttl := d.requestTTL(request)
+	now := time.Now().Unix()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.purgeInterval > 0 && now-d.lastPurge >= d.purgeInterval {
+		d.purge(now)
+	}
+	entry, ok := d.entries[key]
+	if ok && (ttl <= 0 || now-entry.seen < ttl) {
+		return true
+	}
+	if ok {
+		d.Stats.IncValue("dupe_filter/revisited", 1, 0)
+	}
+
+	entry = ttlEntry{seen: now, ttl: ttl}
+	d.entries[key] = entry
+	if d.writer != nil {
+		if _, err := d.writer.Write(ttlRecord(key, entry)); err != nil {
+			RequestLogger(d.Logger, request).Errorw("指纹写入磁盘失败", "error", err)
+		}
+	}
+	d.updateStats()
+	return false
+}
+
+// updateStats 记录指纹数量及估算的内存占用
+func (d *TTLDupeFilter) updateStats() {
+	d.Stats.SetValue("dupe_filter/fingerprints", len(d.entries))
+	d.Stats.SetValue("dupe_filter/memory_usage", len(d.entries)*ttlEntrySize)
+}
+
+func (d *TTLDupeFilter) Flush() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.flush()
+}
+
+// flush 将缓冲的指纹写入磁盘，调用时需持有d.mu
+func (d *TTLDupeFilter) flush() error {
+	if d.writer == nil {
+		return nil
+	}
+	if err := d.writer.Flush(); err != nil {
+		return err
+	}
+	return d.file.Sync()
+}
+
+func (d *TTLDupeFilter) Close(spider *Spider) {
+	d.mu.Lock()
+	if err := d.flush(); err != nil {
+		d.Logger.Errorw("指纹写入磁盘失败", "error", err)
+	}
+	if d.file != nil {
+		_ = d.file.Close()
+		// 关闭后仍有RequestSeen调用时不再写入文件
+		d.file, d.writer = nil, nil
+		d.purge(time.Now().Unix())
+		if err := d.rewrite(); err != nil {
+			d.Logger.Errorw("指纹写入磁盘失败", "path", d.path, "error", err)
+		}
+	}
+	d.mu.Unlock()
+	d.BaseSpiderModule.Close(spider)
+}
+
+func (d *TTLDupeFilter) RequestFingerprint(request *Request) string {
+	return d.fingerprinter.Fingerprint(request)
+}
+
+func (d *TTLDupeFilter) Log(request *Request) {
+	RequestLogger(d.Logger, request).Debug("Request已滤除")
+	d.Stats.IncValue("dupe_filter/filtered", 1, 0)
+}
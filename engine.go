@@ -78,9 +78,9 @@ func (eg *EnginerImpl) IsAllDone() bool {
 }
 
 func (eg *EnginerImpl) emit(signal Signaler) {
+	eg.Add(1)
+	eg.wg.Add(1)
 	go func() {
-		eg.Add(1)
-		eg.wg.Add(1)
 		defer func() {
 			eg.Done()
 			eg.wg.Done()
@@ -117,10 +117,7 @@ func (eg *EnginerImpl) FromSpider(spider *Spider) {
 	itemSLotStr := container.GetWithDefault[string](spider.Settings, "ITEM_SLOT_STRUCT", "ItemSlotImpl")
 	eg.itemSlot = GetAndAssertComponent[ItemSloter](itemSLotStr)
 	eg.itemSlot.FromSpider(spider)
-	//requestSlotStr := spider.Settings.GetStringWithDefault("REQUEST_SLOT_STRUCT", "RequestSlotImpl")
-	requestSlotStr := container.GetWithDefault[string](spider.Settings, "REQUEST_SLOT_STRUCT", "RequestSlotImpl")
-	eg.requestSlot = GetAndAssertComponent[RequestSloter](requestSlotStr)
-	eg.requestSlot.FromSpider(spider)
+	eg.requestSlot = spider.requestSlot
 
 	// 注册信号的回调函数
 	eg.signal.Connect(eg.spiderOpened, StSpiderOpened, 50)
@@ -147,18 +144,18 @@ func (eg *EnginerImpl) FromSpider(spider *Spider) {
 }
 
 func (eg *EnginerImpl) Start(spider *Spider) {
+	eg.wg.Add(1)
 	go func() {
-		eg.wg.Add(1)
 		defer eg.wg.Done()
 		eg.processInterrupt(spider)
 	}()
+	eg.wg.Add(1)
 	go func() {
-		eg.wg.Add(1)
 		defer eg.wg.Done()
 		eg.processItem(spider)
 	}()
+	eg.wg.Add(1)
 	go func() {
-		eg.wg.Add(1)
 		defer eg.wg.Done()
 		eg.processDownloader(spider)
 	}()
@@ -172,7 +169,6 @@ func (eg *EnginerImpl) Close(spider *Spider) {
 	eg.wg.Wait()
 	eg.itemSlot.Close(spider)
 	eg.responseSlot.Close(spider)
-	eg.requestSlot.Close(spider)
 	eg.BaseSpiderModule.Close(spider)
 }
 
@@ -187,6 +183,7 @@ func (eg *EnginerImpl) processInterrupt(spider *Spider) {
 				// 第一次接收到信号，停止从scheduler调度新请求
 				eg.Logger.Info("接收到中断信号，正在优雅关闭...", "signal", sig)
 				eg.setFlagTrue()
+				eg.flush(spider)
 			} else {
 				// 第二次接收到信号，强制退出
 				eg.Logger.Info("再次接收到中断信号，强制退出", "signal", sig)
@@ -198,6 +195,24 @@ func (eg *EnginerImpl) processInterrupt(spider *Spider) {
 	}
 }
 
+// flush 将实现了Flusher的模块中的数据写入磁盘，避免强制退出时丢失
+func (eg *EnginerImpl) flush(spider *Spider) {
+	modules := []SpiderModuler{spider.scheduler}
+	for _, mw := range spider.downloaderManager.Middlewares() {
+		modules = append(modules, mw)
+	}
+	for _, ext := range spider.extensionManager.Extensions() {
+		modules = append(modules, ext)
+	}
+	for _, module := range modules {
+		if f, ok := module.(Flusher); ok {
+			if err := f.Flush(); err != nil {
+				eg.Logger.Errorw("写入磁盘失败", "module", module.Name(), "error", err)
+			}
+		}
+	}
+}
+
 // 处理Item循环
 func (eg *EnginerImpl) processItem(spider *Spider) {
 	for {
@@ -248,11 +263,12 @@ func (eg *EnginerImpl) processScheduler(spider *Spider) {
 		select {
 		case <-eg.schedulerChan:
 			//fmt.Println("1")
-			if (eg.requestSlot.IsFree() || eg.requestSlot.IsEmpty()) &&
+			if eg.isRequestSlotFree(spider) &&
 				eg.itemSlot.IsFree() && eg.responseSlot.IsFree() {
 				if spider.scheduler.HasPendingRequests() && !eg.needStop() {
-					request := spider.scheduler.NextRequest()
-					eg.emit(NewRequestLeftSchedulerSignal(SenderScheduler, request, spider))
+					if request := spider.scheduler.NextRequest(); request != nil {
+						eg.emit(NewRequestLeftSchedulerSignal(SenderScheduler, request, spider))
+					}
 					eg.triggerScheduler()
 				}
 			}
@@ -272,20 +288,34 @@ func (eg *EnginerImpl) processScheduler(spider *Spider) {
 	}
 }
 
+// 判断下载slot能否接收新的Request，其他slot繁忙时，下一个Request所属的slot空闲也可以出队
+func (eg *EnginerImpl) isRequestSlotFree(spider *Spider) bool {
+	if eg.requestSlot.IsFree() || eg.requestSlot.IsEmpty() {
+		return true
+	}
+	if peeker, ok := spider.scheduler.(RequestPeeker); ok {
+		if request := peeker.PeekRequest(); request != nil {
+			return eg.requestSlot.IsSlotFree(eg.requestSlot.SlotKey(request))
+		}
+	}
+	return false
+}
+
 // 判断是否全部爬取完成
+// 调度器最后判断，避免查询调度器期间正在处理的信号加入新的Request（如调度器需要查询数据库时）
 func (eg *EnginerImpl) isIdle(spider *Spider) bool {
-	return (!spider.scheduler.HasPendingRequests() || eg.needStop()) &&
-		eg.requestSlot.IsEmpty() &&
+	return eg.requestSlot.IsEmpty() &&
 		eg.itemSlot.IsEmpty() &&
 		spider.Signal.IsAllDone() &&
-		eg.IsAllDone()
+		eg.IsAllDone() &&
+		(eg.needStop() || !spider.scheduler.HasPendingRequests())
 }
 
 // 触发调度器
 func (eg *EnginerImpl) triggerScheduler() {
+	eg.wg.Add(1)
+	eg.Add(1)
 	go func() {
-		eg.wg.Add(1)
-		eg.Add(1)
 		defer func() {
 			eg.wg.Done()
 			eg.Done()
@@ -303,9 +333,9 @@ func (eg *EnginerImpl) triggerScheduler() {
 }
 
 func (eg *EnginerImpl) triggerItem() {
+	eg.wg.Add(1)
+	eg.Add(1)
 	go func() {
-		eg.wg.Add(1)
-		eg.Add(1)
 		defer func() {
 			eg.wg.Done()
 			eg.Done()
@@ -357,11 +387,11 @@ func (eg *EnginerImpl) startsLeftSpider(results Results, spider *Spider) {
 func (eg *EnginerImpl) startsLeftSpiderMiddleware(results Results, spider *Spider) {
 	eg.Logger.Debug("开始分发起始请求处理结果")
 
+	eg.Add(1)
+	eg.wg.Add(1)
 	go func() {
 		defer eg.Logger.Debug("结束分发起始请求处理结果")
 
-		eg.Add(1)
-		eg.wg.Add(1)
 		defer func() {
 			eg.Done()
 			eg.wg.Done()
@@ -458,6 +488,10 @@ func (eg *EnginerImpl) requestReachedDownloaderMiddleware(request *Request, spid
 	result, idx, err := spider.downloaderManager.ProcessRequest(request, spider)
 
 	if err != nil {
+		if errors.Is(err, ErrRequestDeferred) {
+			logger.Debug("Request暂缓处理")
+			return
+		}
 		if errors.Is(err, ErrDropRequest) {
 			LogSpiderModulerError(
 				logger, zap.InfoLevel,
@@ -653,11 +687,11 @@ func (eg *EnginerImpl) resultsLeftSpiderMiddleware(response *Response, results R
 	logger := ResponseLogger(eg.Logger, response)
 	logger.Debug("开始分发爬虫解析结果")
 
+	eg.Add(1)
+	eg.wg.Add(1)
 	go func() {
 		defer logger.Debug("结束分发爬虫解析结果")
 
-		eg.Add(1)
-		eg.wg.Add(1)
 		defer func() {
 			eg.Done()
 			eg.wg.Done()
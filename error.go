@@ -9,7 +9,36 @@ var ErrDropItem = errors.New("drop_item")
 var ErrDropRequest = errors.New("drop_request")
 var ErrDropSignal = errors.New("drop_signal")
 
+// ErrRequestDeferred 下载器中间件暂缓处理Request，Request之后由该中间件重新发出
+var ErrRequestDeferred = errors.New("request_deferred")
+
 var ErrHttpCode = fmt.Errorf("http_code: %w", ErrDropRequest)
+var ErrOffsiteRequest = fmt.Errorf("offsite_request: %w", ErrDropRequest)
+var ErrRedirectMaxReached = fmt.Errorf("redirect_max_reached: %w", ErrDropRequest)
+var ErrDownloadMaxSize = fmt.Errorf("download_max_size: %w", ErrDropRequest)
+var ErrRobotsTxtForbidden = fmt.Errorf("robotstxt_forbidden: %w", ErrDropRequest)
+var ErrHttpCacheMissing = fmt.Errorf("httpcache_missing: %w", ErrDropRequest)
+var ErrCircuitOpen = fmt.Errorf("circuit_open: %w", ErrDropRequest)
+var ErrRequestTableDuplicate = errors.New("request_table_duplicate")
+var ErrRequestTableLeaseLost = errors.New("request_table_lease_lost")
+
+// DownloadSizeError Response大小超过限制时返回的错误，可通过errors.Is(err, ErrDownloadMaxSize)识别
+type DownloadSizeError struct {
+	Url     string
+	Size    int
+	MaxSize int
+}
+
+func (e *DownloadSizeError) Error() string {
+	if e.Url == "" {
+		return fmt.Sprintf("download_max_size: response size %d exceeds %d bytes", e.Size, e.MaxSize)
+	}
+	return fmt.Sprintf("download_max_size: response size %d of %s exceeds %d bytes", e.Size, e.Url, e.MaxSize)
+}
+
+func (e *DownloadSizeError) Unwrap() error {
+	return ErrDownloadMaxSize
+}
 
 //var ErrUnhandledError = errors.New("unhandled_error")
 //var ErrNotImplemented = errors.New("not_implemented")
@@ -2,6 +2,8 @@ package xspider
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -11,6 +13,8 @@ import (
 func init() {
 	RegisterSpiderModuler(&CoreStatsExtension{})
 	RegisterSpiderModuler(&LogStatsExtension{})
+	RegisterSpiderModuler(&SpiderStateExtension{})
+	RegisterSpiderModuler(&AutoThrottleExtension{})
 }
 
 type CoreStatsExtension struct {
@@ -155,3 +159,151 @@ func (ls *LogStatsExtension) spiderClosed(reason string, spider *Spider) {
 	ls.Stats.SetValue("responses_per_minute", rpmFinal)
 	ls.Stats.SetValue("items_per_minute", ipmFinal)
 }
+
+// SpiderStateExtension 设置了JOBDIR时将Spider.State保存在JOBDIR/spider.state中，再次运行时恢复
+type SpiderStateExtension struct {
+	BaseSpiderModule
+	path  string
+	state container.JsonMap
+	mu    sync.Mutex
+}
+
+func (ss *SpiderStateExtension) ConnectSignal(sm SignalManager, idx int) {}
+
+func (ss *SpiderStateExtension) Name() string {
+	return "SpiderStateExtension"
+}
+
+func (ss *SpiderStateExtension) FromSpider(spider *Spider) {
+	InitBaseSpiderModule(&ss.BaseSpiderModule, spider, ss.Name())
+	jobdir := container.GetWithDefault[string](spider.Settings, "JOBDIR", "")
+	if jobdir == "" {
+		return
+	}
+	ss.path = filepath.Join(jobdir, "spider.state")
+	ss.state = spider.State
+	data, err := os.ReadFile(ss.path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		err = ss.state.Loads(data)
+	}
+	if err != nil {
+		ss.Logger.Fatalw("读取爬虫状态失败", "path", ss.path, "error", err)
+	}
+	ss.Logger.Infow("已恢复爬虫状态", "path", ss.path)
+}
+
+func (ss *SpiderStateExtension) Flush() error {
+	if ss.path == "" {
+		return nil
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	data, err := ss.state.Dumps()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(ss.path), 0755); err != nil {
+		return err
+	}
+	tmp := ss.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ss.path)
+}
+
+func (ss *SpiderStateExtension) Close(spider *Spider) {
+	if err := ss.Flush(); err != nil {
+		ss.Logger.Errorw("保存爬虫状态失败", "path", ss.path, "error", err)
+	}
+	ss.BaseSpiderModule.Close(spider)
+}
+
+// AutoThrottleExtension AUTOTHROTTLE_ENABLED为true时根据下载延迟自动调整各子slot的下载间隔。
+// 新的子slot由RequestSlotImpl以AUTOTHROTTLE_START_DELAY作为初始间隔（REQUEST_SLOTS中单独设置的子slot除外），收到Response后目标间隔为延迟除以AUTOTHROTTLE_TARGET_CONCURRENCY，
+// 新的间隔取当前间隔与目标间隔的平均值且不小于目标间隔，并限制在DOWNLOAD_DELAY与AUTOTHROTTLE_MAX_DELAY之间。
+// 状态码不是200的Response只会增大间隔，Ctx中autothrottle_dont_adjust_delay为true或使用缓存的Response不做调整，
+// 使用令牌桶限速的子slot不做调整
+type AutoThrottleExtension struct {
+	BaseSpiderModule
+	enabled           bool
+	debug             bool
+	targetConcurrency float64
+	minDelay          time.Duration
+	maxDelay          time.Duration
+	slot              RequestSloter
+	mu                sync.Mutex
+}
+
+func (at *AutoThrottleExtension) ConnectSignal(sm SignalManager, idx int) {
+	if !at.enabled {
+		return
+	}
+	sm.Connect(at.responseLeftDownloader, StResponseLeftDownloader, idx)
+}
+
+func (at *AutoThrottleExtension) Name() string {
+	return "AutoThrottleExtension"
+}
+
+func (at *AutoThrottleExtension) FromSpider(spider *Spider) {
+	InitBaseSpiderModule(&at.BaseSpiderModule, spider, at.Name())
+	at.enabled = container.GetWithDefault[bool](spider.Settings, "AUTOTHROTTLE_ENABLED", false)
+	if !at.enabled {
+		return
+	}
+	at.debug = container.GetWithDefault[bool](spider.Settings, "AUTOTHROTTLE_DEBUG", false)
+	at.targetConcurrency = container.GetWithDefault[float64](spider.Settings, "AUTOTHROTTLE_TARGET_CONCURRENCY", 1.0)
+	if at.targetConcurrency <= 0 {
+		at.Logger.Fatalw("AUTOTHROTTLE_TARGET_CONCURRENCY必须大于0", "value", at.targetConcurrency)
+	}
+	at.minDelay = SecondsToDuration(container.GetWithDefault[float64](spider.Settings, "DOWNLOAD_DELAY", 1))
+	at.maxDelay = SecondsToDuration(container.GetWithDefault[float64](spider.Settings, "AUTOTHROTTLE_MAX_DELAY", 60))
+	at.slot = spider.requestSlot
+	at.Logger.Infow("模块初始化完成",
+		"max_delay", at.maxDelay.String(),
+		"target_concurrency", at.targetConcurrency)
+}
+
+func (at *AutoThrottleExtension) responseLeftDownloader(request *Request, response *Response, spider *Spider) {
+	if container.GetWithDefault[bool](request.Ctx, "autothrottle_dont_adjust_delay", false) ||
+		container.GetWithDefault[bool](response.Ctx, "cached", false) {
+		return
+	}
+	latency, err := container.Get[float64](request.Ctx, "download_latency")
+	if err != nil {
+		return
+	}
+
+	key := at.slot.SlotKey(request)
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	config, ok := at.slot.SlotConfig(key)
+	if !ok || config.Rate > 0 {
+		return
+	}
+	oldDelay := config.Delay
+	newDelay := at.adjustDelay(oldDelay, SecondsToDuration(latency))
+	// 出错的Response延迟可能很低，不能以此降低间隔
+	if response.StatusCode != 200 && newDelay <= oldDelay {
+		return
+	}
+	config.Delay = newDelay
+	at.slot.SetSlotConfig(key, config)
+
+	if at.debug {
+		at.Logger.Infow("调整下载间隔", "slot", key,
+			"delay", newDelay.String(), "delay_diff", (newDelay - oldDelay).String(),
+			"latency", SecondsToDuration(latency).String(), "size", len(response.Body))
+	}
+}
+
+// adjustDelay 根据下载延迟计算新的下载间隔
+func (at *AutoThrottleExtension) adjustDelay(delay, latency time.Duration) time.Duration {
+	target := time.Duration(float64(latency) / at.targetConcurrency)
+	newDelay := max((delay+target)/2, target)
+	return min(max(at.minDelay, newDelay), at.maxDelay)
+}
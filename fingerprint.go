@@ -0,0 +1,182 @@
+package xspider
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/xue0228/xspider/container"
+)
+
+func init() {
+	RegisterSpiderModuler(&RequestFingerprinterImpl{})
+}
+
+// defaultPorts 规范化Url时省略的默认端口
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ftp":   "21",
+}
+
+// CanonicalizeUrl 规范化Url：scheme和host转为小写，省略默认端口，查询参数按键值排序，
+// 删除与stripParams匹配的查询参数（支持path.Match通配符，如utm_*），keepFragments为false时删除fragment
+func CanonicalizeUrl(u *url.URL, keepFragments bool, stripParams []string) string {
+	c := *u
+	c.Scheme = strings.ToLower(c.Scheme)
+
+	host := strings.ToLower(c.Hostname())
+	port := c.Port()
+	if port == defaultPorts[c.Scheme] {
+		port = ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	c.Host = host
+	if c.Path == "" && c.Host != "" {
+		c.Path = "/"
+	}
+
+	type pair struct{ key, value string }
+	var pairs []pair
+	for _, part := range strings.Split(c.RawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(part, "=")
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+		if v, err := url.QueryUnescape(value); err == nil {
+			value = v
+		}
+		if matchAny(key, stripParams) {
+			continue
+		}
+		pairs = append(pairs, pair{key, value})
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].key != pairs[j].key {
+			return pairs[i].key < pairs[j].key
+		}
+		return pairs[i].value < pairs[j].value
+	})
+	query := make([]string, 0, len(pairs))
+	for _, p := range pairs {
+		query = append(query, url.QueryEscape(p.key)+"="+url.QueryEscape(p.value))
+	}
+	c.RawQuery = strings.Join(query, "&")
+	c.ForceQuery = false
+
+	if !keepFragments {
+		c.Fragment = ""
+		c.RawFragment = ""
+	}
+	return c.String()
+}
+
+func matchAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestFingerprinterImpl 根据规范化后的Url、Method及Body计算SHA1指纹，
+// REQUEST_FINGERPRINTER_STRIP_PARAMS设置计算指纹时忽略的查询参数，REQUEST_FINGERPRINTER_KEEP_FRAGMENTS设置是否保留fragment，
+// REQUEST_FINGERPRINTER_INCLUDE_HEADERS和REQUEST_FINGERPRINTER_INCLUDE_CTX设置需要计入指纹的请求头和Ctx字段。
+// 规范化后的指纹与旧版本保存的指纹不匹配，继续使用已有的JOBDIR/requests.seen、HTTP缓存目录或RequestTable.Fp时，
+// 可以将REQUEST_FINGERPRINTER_VERSION设置为1，使用旧版本的Request.Fingerprint(nil, false)，此时上述选项均不生效
+type RequestFingerprinterImpl struct {
+	BaseSpiderModule
+	legacy         bool
+	stripParams    []string
+	keepFragments  bool
+	includeHeaders []string
+	includeCtx     []string
+}
+
+// NewRequestFingerprinter 根据设置项创建RequestFingerprinterImpl，用于在Spider之外计算与Spider相同的指纹，如RequestTable
+func NewRequestFingerprinter(settings container.JsonMap) *RequestFingerprinterImpl {
+	f := &RequestFingerprinterImpl{}
+	f.configure(settings)
+	return f
+}
+
+func (f *RequestFingerprinterImpl) Name() string {
+	return "RequestFingerprinterImpl"
+}
+
+func (f *RequestFingerprinterImpl) FromSpider(spider *Spider) {
+	InitBaseSpiderModule(&f.BaseSpiderModule, spider, f.Name())
+	f.configure(spider.Settings)
+	f.Logger.Info("模块初始化完成")
+}
+
+func (f *RequestFingerprinterImpl) configure(settings container.JsonMap) {
+	f.stripParams = container.GetWithDefault[[]string](settings, "REQUEST_FINGERPRINTER_STRIP_PARAMS", []string{})
+	f.keepFragments = container.GetWithDefault[bool](settings, "REQUEST_FINGERPRINTER_KEEP_FRAGMENTS", false)
+	f.includeHeaders = container.GetWithDefault[[]string](settings, "REQUEST_FINGERPRINTER_INCLUDE_HEADERS", []string{})
+	f.includeCtx = container.GetWithDefault[[]string](settings, "REQUEST_FINGERPRINTER_INCLUDE_CTX", []string{})
+	f.legacy = container.GetWithDefault[int](settings, "REQUEST_FINGERPRINTER_VERSION", 2) < 2
+}
+
+func (f *RequestFingerprinterImpl) Fingerprint(request *Request) string {
+	if f.legacy {
+		return request.Fingerprint(nil, false)
+	}
+	data := struct {
+		Method  string              `json:"method"`
+		Url     string              `json:"url"`
+		Body    []byte              `json:"body"`
+		Headers map[string][]string `json:"headers,omitempty"`
+		Ctx     map[string]any      `json:"ctx,omitempty"`
+	}{
+		Method: strings.ToUpper(request.Method),
+		Body:   ReadRequestBody(request),
+	}
+	if data.Method == "" {
+		data.Method = http.MethodGet
+	}
+	if request.Url != nil {
+		data.Url = CanonicalizeUrl(request.Url, f.keepFragments, f.stripParams)
+	}
+	if len(f.includeHeaders) > 0 && request.Headers != nil {
+		data.Headers = make(map[string][]string)
+		for _, key := range f.includeHeaders {
+			if values := request.Headers.Values(key); len(values) > 0 {
+				data.Headers[http.CanonicalHeaderKey(key)] = values
+			}
+		}
+	}
+	if len(f.includeCtx) > 0 && request.Ctx != nil {
+		data.Ctx = make(map[string]any)
+		for _, key := range f.includeCtx {
+			if value, err := request.Ctx.Get(key); err == nil {
+				// 无法序列化为JSON的值不计入指纹，避免影响其他字段
+				if _, err = json.Marshal(value); err != nil {
+					if f.Logger != nil {
+						RequestLogger(f.Logger, request).Warnw("Ctx字段无法序列化，不计入指纹", "key", key, "error", err)
+					}
+					continue
+				}
+				data.Ctx[key] = value
+			}
+		}
+	}
+
+	b, _ := json.Marshal(data)
+	sum := sha1.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
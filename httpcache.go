@@ -0,0 +1,378 @@
+package xspider
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xue0228/xspider/container"
+)
+
+func init() {
+	RegisterSpiderModuler(&FilesystemHttpCacheStorage{})
+	RegisterSpiderModuler(&DummyHttpCachePolicy{})
+	RegisterSpiderModuler(&RFC9111HttpCachePolicy{})
+}
+
+// httpCacheMeta 缓存的元数据，写入完成后才会保存，用于判断缓存是否完整
+type httpCacheMeta struct {
+	Url        string      `json:"url"`
+	Method     string      `json:"method"`
+	StatusCode int         `json:"status_code"`
+	Headers    http.Header `json:"headers"`
+	Timestamp  int64       `json:"timestamp"`
+}
+
+// FilesystemHttpCacheStorage 将Response缓存在HTTPCACHE_DIR/爬虫名称下，每个Request的缓存按指纹保存在单独的目录中，
+// HTTPCACHE_EXPIRATION_SECS大于0时超过该时间的缓存视为过期
+type FilesystemHttpCacheStorage struct {
+	BaseSpiderModule
+	fingerprinter RequestFingerprinter
+	dir           string
+	expiration    time.Duration
+}
+
+func (s *FilesystemHttpCacheStorage) Name() string {
+	return "FilesystemHttpCacheStorage"
+}
+
+func (s *FilesystemHttpCacheStorage) FromSpider(spider *Spider) {
+	InitBaseSpiderModule(&s.BaseSpiderModule, spider, s.Name())
+	s.fingerprinter = spider.Fingerprinter
+	dir := container.GetWithDefault[string](spider.Settings, "HTTPCACHE_DIR", "httpcache")
+	s.dir = filepath.Join(dir, spider.Name)
+	expiration := container.GetWithDefault[int](spider.Settings, "HTTPCACHE_EXPIRATION_SECS", 0)
+	s.expiration = time.Duration(expiration) * time.Second
+	s.Logger.Infow("模块初始化完成", "dir", s.dir)
+}
+
+func (s *FilesystemHttpCacheStorage) requestPath(request *Request) string {
+	fp := s.fingerprinter.Fingerprint(request)
+	return filepath.Join(s.dir, fp[:2], fp)
+}
+
+func (s *FilesystemHttpCacheStorage) RetrieveResponse(request *Request) *Response {
+	path := s.requestPath(request)
+	data, err := os.ReadFile(filepath.Join(path, "meta.json"))
+	if err != nil {
+		return nil
+	}
+	var meta httpCacheMeta
+	if err = json.Unmarshal(data, &meta); err != nil {
+		RequestLogger(s.Logger, request).Warnw("读取缓存失败", "path", path, "error", err)
+		return nil
+	}
+	cachedAt := time.Unix(meta.Timestamp, 0)
+	if s.expiration > 0 && time.Since(cachedAt) > s.expiration {
+		return nil
+	}
+	body, err := os.ReadFile(filepath.Join(path, "response_body"))
+	if err != nil {
+		RequestLogger(s.Logger, request).Warnw("读取缓存失败", "path", path, "error", err)
+		return nil
+	}
+
+	headers := meta.Headers
+	if headers == nil {
+		headers = http.Header{}
+	}
+	// 没有Date时以缓存时间计算缓存的年龄
+	if headers.Get("Date") == "" {
+		headers.Set("Date", cachedAt.UTC().Format(http.TimeFormat))
+	}
+	return &Response{
+		StatusCode: meta.StatusCode,
+		Body:       body,
+		Ctx:        request.Ctx,
+		Request:    request,
+		Headers:    &headers,
+	}
+}
+
+func (s *FilesystemHttpCacheStorage) StoreResponse(request *Request, response *Response) {
+	path := s.requestPath(request)
+	if err := s.store(path, request, response); err != nil {
+		RequestLogger(s.Logger, request).Warnw("写入缓存失败", "path", path, "error", err)
+	}
+}
+
+func (s *FilesystemHttpCacheStorage) store(path string, request *Request, response *Response) error {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	// 先删除元数据，避免写入过程中读取到不完整的缓存
+	metaPath := filepath.Join(path, "meta.json")
+	if err := os.Remove(metaPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	body, err := response.BodyReader()
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	f, err := os.Create(filepath.Join(path, "response_body"))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	var headers http.Header
+	if response.Headers != nil {
+		headers = response.Headers.Clone()
+	}
+	data, err := json.Marshal(httpCacheMeta{
+		Url:        request.Url.String(),
+		Method:     request.Method,
+		StatusCode: response.StatusCode,
+		Headers:    headers,
+		Timestamp:  time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	tmp := metaPath + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, metaPath)
+}
+
+// httpCachePolicyBase 缓存策略的公共设置
+type httpCachePolicyBase struct {
+	BaseSpiderModule
+	ignoreSchemes []string
+}
+
+func (p *httpCachePolicyBase) init(spider *Spider, name string) {
+	InitBaseSpiderModule(&p.BaseSpiderModule, spider, name)
+	p.ignoreSchemes = container.GetWithDefault[[]string](spider.Settings, "HTTPCACHE_IGNORE_SCHEMES", []string{"file"})
+}
+
+func (p *httpCachePolicyBase) ShouldCacheRequest(request *Request) bool {
+	return !slices.Contains(p.ignoreSchemes, request.Url.Scheme)
+}
+
+// DummyHttpCachePolicy 缓存所有Response且缓存始终有效，不考虑Cache-Control，
+// 状态码在HTTPCACHE_IGNORE_HTTP_CODES中的Response不缓存
+type DummyHttpCachePolicy struct {
+	httpCachePolicyBase
+	ignoreHttpCodes []int
+}
+
+func (p *DummyHttpCachePolicy) Name() string {
+	return "DummyHttpCachePolicy"
+}
+
+func (p *DummyHttpCachePolicy) FromSpider(spider *Spider) {
+	p.init(spider, p.Name())
+	p.ignoreHttpCodes = container.GetWithDefault[[]int](spider.Settings, "HTTPCACHE_IGNORE_HTTP_CODES", []int{})
+}
+
+func (p *DummyHttpCachePolicy) ShouldCacheResponse(response *Response, request *Request) bool {
+	return !slices.Contains(p.ignoreHttpCodes, response.StatusCode)
+}
+
+func (p *DummyHttpCachePolicy) IsCachedResponseFresh(cached *Response, request *Request) bool {
+	return true
+}
+
+func (p *DummyHttpCachePolicy) IsCachedResponseValid(cached *Response, response *Response, request *Request) bool {
+	return true
+}
+
+// RFC9111HttpCachePolicy 按RFC 9111处理Cache-Control、Expires等缓存头，
+// 缓存过期后使用ETag、Last-Modified发送条件请求验证缓存。
+// HTTPCACHE_ALWAYS_STORE为true时缓存所有Response，HTTPCACHE_IGNORE_RESPONSE_CACHE_CONTROLS中的指令将被忽略
+type RFC9111HttpCachePolicy struct {
+	httpCachePolicyBase
+	alwaysStore            bool
+	ignoreResponseControls []string
+}
+
+func (p *RFC9111HttpCachePolicy) Name() string {
+	return "RFC9111HttpCachePolicy"
+}
+
+func (p *RFC9111HttpCachePolicy) FromSpider(spider *Spider) {
+	p.init(spider, p.Name())
+	p.alwaysStore = container.GetWithDefault[bool](spider.Settings, "HTTPCACHE_ALWAYS_STORE", false)
+	p.ignoreResponseControls = container.GetWithDefault[[]string](spider.Settings, "HTTPCACHE_IGNORE_RESPONSE_CACHE_CONTROLS", []string{})
+}
+
+// parseCacheControl 解析Cache-Control，指令名统一为小写
+func parseCacheControl(values []string) map[string]string {
+	res := make(map[string]string)
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if k == "" {
+				continue
+			}
+			res[strings.ToLower(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return res
+}
+
+func (p *RFC9111HttpCachePolicy) responseCacheControl(response *Response) map[string]string {
+	cc := parseCacheControl(response.Headers.Values("Cache-Control"))
+	for _, v := range p.ignoreResponseControls {
+		delete(cc, strings.ToLower(v))
+	}
+	return cc
+}
+
+func (p *RFC9111HttpCachePolicy) ShouldCacheRequest(request *Request) bool {
+	if !p.httpCachePolicyBase.ShouldCacheRequest(request) {
+		return false
+	}
+	_, noStore := parseCacheControl(request.Headers.Values("Cache-Control"))["no-store"]
+	return !noStore
+}
+
+func (p *RFC9111HttpCachePolicy) ShouldCacheResponse(response *Response, request *Request) bool {
+	cc := p.responseCacheControl(response)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	// 304是对条件请求的响应，不包含完整的内容
+	if response.StatusCode == http.StatusNotModified {
+		return false
+	}
+	if p.alwaysStore {
+		return true
+	}
+	if _, ok := cc["max-age"]; ok {
+		return true
+	}
+	if response.Headers.Get("Expires") != "" {
+		return true
+	}
+	switch response.StatusCode {
+	case http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusPermanentRedirect:
+		return true
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusUnauthorized:
+		return response.Headers.Get("Last-Modified") != "" || response.Headers.Get("ETag") != ""
+	default:
+		return false
+	}
+}
+
+func (p *RFC9111HttpCachePolicy) IsCachedResponseFresh(cached *Response, request *Request) bool {
+	cc := p.responseCacheControl(cached)
+	ccReq := parseCacheControl(request.Headers.Values("Cache-Control"))
+	_, noCache := cc["no-cache"]
+	_, reqNoCache := ccReq["no-cache"]
+	if !noCache && !reqNoCache {
+		now := time.Now()
+		lifetime := p.freshnessLifetime(cached, cc, now)
+		age := p.currentAge(cached, now)
+
+		// Request中的max-age限制缓存的最大年龄
+		if v, ok := ccReq["max-age"]; ok {
+			if maxAge, err := strconv.Atoi(v); err == nil && time.Duration(maxAge)*time.Second < lifetime {
+				lifetime = time.Duration(maxAge) * time.Second
+			}
+		}
+		if age < lifetime {
+			return true
+		}
+
+		// Request中的max-stale允许使用过期的缓存，Response设置了must-revalidate时除外
+		if v, ok := ccReq["max-stale"]; ok {
+			if _, ok := cc["must-revalidate"]; !ok {
+				if v == "" {
+					return true
+				}
+				if maxStale, err := strconv.Atoi(v); err == nil && age < lifetime+time.Duration(maxStale)*time.Second {
+					return true
+				}
+			}
+		}
+	}
+
+	// 缓存需要验证，添加条件请求头
+	if v := cached.Headers.Get("Last-Modified"); v != "" {
+		request.Headers.Set("If-Modified-Since", v)
+	}
+	if v := cached.Headers.Get("ETag"); v != "" {
+		request.Headers.Set("If-None-Match", v)
+	}
+	return false
+}
+
+func (p *RFC9111HttpCachePolicy) IsCachedResponseValid(cached *Response, response *Response, request *Request) bool {
+	// 源站出错时在允许的情况下继续使用缓存
+	if response.StatusCode >= 500 {
+		_, ok := p.responseCacheControl(cached)["must-revalidate"]
+		return !ok
+	}
+	return response.StatusCode == http.StatusNotModified
+}
+
+// freshnessLifetime 缓存的有效期，依次使用max-age、Expires和Last-Modified计算
+func (p *RFC9111HttpCachePolicy) freshnessLifetime(response *Response, cc map[string]string, now time.Time) time.Duration {
+	if v, ok := cc["max-age"]; ok {
+		if maxAge, err := strconv.Atoi(v); err == nil {
+			return time.Duration(max(maxAge, 0)) * time.Second
+		}
+	}
+
+	date := headerTime(response.Headers, "Date", now)
+	if v := response.Headers.Get("Expires"); v != "" {
+		// 无法解析的Expires视为已过期
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		return max(expires.Sub(date), 0)
+	}
+
+	// 启发式有效期为距离上次修改时间的10%
+	if v := response.Headers.Get("Last-Modified"); v != "" {
+		if lastModified, err := http.ParseTime(v); err == nil && lastModified.Before(date) {
+			return date.Sub(lastModified) / 10
+		}
+	}
+
+	switch response.StatusCode {
+	case http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusPermanentRedirect:
+		return 365 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// currentAge 缓存的当前年龄
+func (p *RFC9111HttpCachePolicy) currentAge(response *Response, now time.Time) time.Duration {
+	age := max(now.Sub(headerTime(response.Headers, "Date", now)), 0)
+	if v := response.Headers.Get("Age"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil && time.Duration(seconds)*time.Second > age {
+			age = time.Duration(seconds) * time.Second
+		}
+	}
+	return age
+}
+
+// headerTime 解析时间类型的响应头，不存在或无法解析时返回def
+func headerTime(headers *http.Header, key string, def time.Time) time.Time {
+	if v := headers.Get(key); v != "" {
+		if t, err := http.ParseTime(v); err == nil {
+			return t
+		}
+	}
+	return def
+}
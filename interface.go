@@ -31,6 +31,16 @@ type SpiderModuler interface {
 	Name() string
 }
 
+// Flusher 可以将内存中的数据写入磁盘的模块，Engine接收到中断信号时调用
+type Flusher interface {
+	Flush() error
+}
+
+// RequestPeeker 可以查看下一个Request但不将其取出的调度器，Engine据此判断该Request所属的slot是否空闲
+type RequestPeeker interface {
+	PeekRequest() *Request
+}
+
 // Scheduler 爬虫调度器
 type Scheduler interface {
 	SpiderModuler
@@ -42,6 +52,13 @@ type Scheduler interface {
 	NextRequest() *Request
 }
 
+// RequestFingerprinter 计算Request的指纹，DupeFilter、HttpCacheStorager等模块共用
+type RequestFingerprinter interface {
+	SpiderModuler
+	// Fingerprint 获取Request的指纹
+	Fingerprint(*Request) string
+}
+
 // DupeFilter 爬虫去重
 type DupeFilter interface {
 	SpiderModuler
@@ -206,14 +223,29 @@ type ItemSloter interface {
 // RequestSloter 用来限制下载器处理Request的并发数及时间间隔
 type RequestSloter interface {
 	SpiderModuler
+	// SlotKey 获取Request所属的子slot
+	SlotKey(*Request) string
 	// Push 当Request加入下载器时调用此方法
 	Push(*Request)
+	// Reserve 为暂时不能加入的Request占用子slot的排队位置，使其参与并发限制及空闲判断，直到调用Unreserve
+	Reserve(*Request)
+	// Unreserve 释放Reserve占用的排队位置
+	Unreserve(*Request)
 	// Finish 当Request下载完毕时调用此方法
 	Finish(*Request)
 	// Pop 取出当前时间点所有子slot中能处理的Request
 	Pop() Requests
 	// IsFree 判断slot是否空闲，为空的子slot不做判定
 	IsFree() bool
+	// IsSlotFree 判断指定的子slot是否还能接收Request，子slot不存在时只判断总并发数
+	IsSlotFree(string) bool
+	// SlotLen 指定的子slot中排队及正在下载的Request数量
+	SlotLen(string) int
+	// SlotConfig 指定的子slot当前的配置，子slot不存在时返回创建时将使用的配置及false
+	SlotConfig(string) (RequestSlotConfig, bool)
+	// SetSlotConfig 修改指定子slot的配置，对已存在的子slot立即生效，子slot被Clear删除后不再保留；
+	// 子slot不存在时作为其创建时使用的配置
+	SetSlotConfig(string, RequestSlotConfig)
 	// IsEmpty 判断slot是否为空
 	IsEmpty() bool
 	// Clear 删除内部不活跃时间达到指定时间的子slot资源
@@ -231,6 +263,29 @@ type PriorityQueuer interface {
 	Len() int
 }
 
+// HttpCacheStorager HttpCacheDownloaderMiddleware使用的缓存存储
+type HttpCacheStorager interface {
+	SpiderModuler
+	// RetrieveResponse 读取Request对应的缓存，没有缓存或缓存已过期时返回nil
+	RetrieveResponse(*Request) *Response
+	// StoreResponse 缓存Request对应的Response
+	StoreResponse(*Request, *Response)
+}
+
+// HttpCachePolicier HttpCacheDownloaderMiddleware使用的缓存策略
+type HttpCachePolicier interface {
+	SpiderModuler
+	// ShouldCacheRequest 判断Request是否使用缓存
+	ShouldCacheRequest(*Request) bool
+	// ShouldCacheResponse 判断Response是否需要缓存
+	ShouldCacheResponse(*Response, *Request) bool
+	// IsCachedResponseFresh 判断缓存是否可以直接使用，不能直接使用时可以为Request添加条件请求头，
+	// 添加的请求头只用于本次下载，得到结果后由HttpCacheDownloaderMiddleware恢复
+	IsCachedResponseFresh(*Response, *Request) bool
+	// IsCachedResponseValid 根据新下载的Response判断缓存是否仍然有效
+	IsCachedResponseValid(*Response, *Response, *Request) bool
+}
+
 type Extensioner interface {
 	SpiderModuler
 	ConnectSignal(SignalManager, int)
@@ -26,7 +26,7 @@ var (
 	}
 	DownloaderMiddlewaresBase = map[string]int{
 		//"OffsiteDownloaderMiddleware":         50,
-		//"RobotsTxtDownloaderMiddleware":       100,
+		"RobotsTxtDownloaderMiddleware":       100,
 		"HttpAuthDownloaderMiddleware":        300,
 		"DownloadTimeoutDownloaderMiddleware": 350,
 		"DefaultHeadersDownloaderMiddleware":  400,
@@ -34,12 +34,12 @@ var (
 		"RetryDownloaderMiddleware":           550,
 		//"AjaxCrawlDownloaderMiddleware":       560,
 		//"MetaRefreshDownloaderMiddleware":     580,
-		//"HttpCompressionDownloaderMiddleware": 590,
-		//"RedirectDownloaderMiddleware":        600,
-		//"CookiesDownloaderMiddleware":         700,
-		//"HttpProxyDownloaderMiddleware":       750,
+		"HttpCompressionDownloaderMiddleware": 590,
+		"RedirectDownloaderMiddleware":        600,
+		"CookiesDownloaderMiddleware":         700,
+		"HttpProxyDownloaderMiddleware":       750,
 		"DownloaderStatsDownloaderMiddleware": 850,
-		//"HttpCacheDownloaderMiddleware":       900,
+		"HttpCacheDownloaderMiddleware":       900,
 	}
 	ExtensionsBase = map[string]int{
 		"CoreStatsExtension": 50,
@@ -48,9 +48,9 @@ var (
 		//"MemoryDebuggerExtension": 500,
 		//"CloseSpiderExtension":    500,
 		//"FeedExporterExtension":   500,
-		"LogStatsExtension": 500,
-		//"SpiderStateExtension":    500,
-		//"AutoThrottleExtension":   500,
+		"LogStatsExtension":     500,
+		"SpiderStateExtension":  500,
+		"AutoThrottleExtension": 500,
 	}
 )
 
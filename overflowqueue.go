@@ -0,0 +1,318 @@
+package xspider
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/xue0228/xspider/container"
+)
+
+func init() {
+	RegisterSpiderModuler(&FIFODiskOverflowPriorityQueue{})
+	RegisterSpiderModuler(&LIFODiskOverflowPriorityQueue{})
+}
+
+// overflowLevel 同一优先级的Request，按出队顺序排列，前面的部分保存在内存中，其余部分保存在磁盘分段文件中
+type overflowLevel struct {
+	memory *list.List
+	// 磁盘分段按出队顺序排列，追加的Request写入末尾的FIFO分段，从内存溢出的Request写入开头的LIFO分段
+	segments []*diskQueue
+	diskSize int
+}
+
+// BaseDiskOverflowPriorityQueue 内存中最多保存PRIORITY_QUEUE_MEMORY_SIZE个Request，
+// 超出的部分按优先级写入PRIORITY_QUEUE_OVERFLOW_DIR下的分段文件，每个分段最多保存PRIORITY_QUEUE_SEGMENT_SIZE个Request。
+// 内存已满时优先将优先级最低的Request溢出到磁盘，磁盘文件在关闭时删除
+type BaseDiskOverflowPriorityQueue struct {
+	BaseSpiderModule
+	dir         string
+	lifo        bool
+	memorySize  int
+	segmentSize int
+	memoryLen   int
+	diskLen     int
+	sequence    int
+	levels      map[int]*overflowLevel
+	mu          sync.Mutex
+}
+
+func (q *BaseDiskOverflowPriorityQueue) init(spider *Spider, name string, lifo bool) {
+	InitBaseSpiderModule(&q.BaseSpiderModule, spider, name)
+	q.lifo = lifo
+	q.memorySize = container.GetWithDefault[int](spider.Settings, "PRIORITY_QUEUE_MEMORY_SIZE", 10000)
+	q.segmentSize = container.GetWithDefault[int](spider.Settings, "PRIORITY_QUEUE_SEGMENT_SIZE", 1000)
+	if q.segmentSize <= 0 {
+		q.segmentSize = 1000
+	}
+	q.levels = make(map[int]*overflowLevel)
+
+	base := container.GetWithDefault[string](spider.Settings, "PRIORITY_QUEUE_OVERFLOW_DIR", "")
+	if base != "" {
+		if err := os.MkdirAll(base, 0755); err != nil {
+			q.Logger.Fatalw("创建队列目录失败", "dir", base, "error", err)
+		}
+	}
+	dir, err := os.MkdirTemp(base, "xspider-queue-")
+	if err != nil {
+		q.Logger.Fatalw("创建队列目录失败", "dir", base, "error", err)
+	}
+	q.dir = dir
+	q.Logger.Infow("模块初始化完成", "dir", q.dir, "memory_size", q.memorySize)
+}
+
+func (q *BaseDiskOverflowPriorityQueue) level(priority int) *overflowLevel {
+	l, ok := q.levels[priority]
+	if !ok {
+		l = &overflowLevel{memory: list.New()}
+		q.levels[priority] = l
+	}
+	return l
+}
+
+// newSegment 新建分段文件，lifo为true时写入的Request先出队
+func (q *BaseDiskOverflowPriorityQueue) newSegment(priority int, lifo bool) (*diskQueue, error) {
+	q.sequence++
+	return openDiskQueue(filepath.Join(q.dir, fmt.Sprintf("%d_%d.segment", priority, q.sequence)), lifo)
+}
+
+// writeDisk 将Request写入磁盘，front为true时写入开头（之后最先出队），否则写入末尾
+func (q *BaseDiskOverflowPriorityQueue) writeDisk(l *overflowLevel, priority int, request *Request, front bool) error {
+	data, err := request.ToJsonMap().Dumps()
+	if err != nil {
+		return err
+	}
+
+	var segment *diskQueue
+	if front {
+		if len(l.segments) > 0 && l.segments[0].lifo && l.segments[0].size < q.segmentSize {
+			segment = l.segments[0]
+		} else {
+			if segment, err = q.newSegment(priority, true); err != nil {
+				return err
+			}
+			l.segments = append([]*diskQueue{segment}, l.segments...)
+		}
+	} else {
+		if n := len(l.segments); n > 0 && !l.segments[n-1].lifo && l.segments[n-1].size < q.segmentSize {
+			segment = l.segments[n-1]
+		} else {
+			if segment, err = q.newSegment(priority, false); err != nil {
+				return err
+			}
+			l.segments = append(l.segments, segment)
+		}
+	}
+
+	if err = segment.push(data); err != nil {
+		return err
+	}
+	l.diskSize++
+	q.diskLen++
+	q.Stats.IncValue("queue/disk/push", 1, 0)
+	return nil
+}
+
+// readDisk 从磁盘读取下一个Request，读完的分段文件将被删除
+func (q *BaseDiskOverflowPriorityQueue) readDisk(l *overflowLevel, remove bool) (*Request, error) {
+	segment := l.segments[0]
+	var data []byte
+	var err error
+	if remove {
+		data, err = segment.pop()
+	} else {
+		data, _, err = segment.peek()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if remove {
+		l.diskSize--
+		q.diskLen--
+		q.Stats.IncValue("queue/disk/pop", 1, 0)
+		if segment.size == 0 {
+			_ = segment.close()
+			l.segments = l.segments[1:]
+		}
+	}
+	return decodeRequest(data)
+}
+
+// spill 将优先级最低的内存中的Request溢出到磁盘，为优先级为priority的新Request腾出空间，返回是否成功
+func (q *BaseDiskOverflowPriorityQueue) spill(priority int) bool {
+	var target *overflowLevel
+	lowest := priority
+	for p, l := range q.levels {
+		// FIFO时同优先级的新Request排在内存中的Request之后，不能替换它们
+		if l.memory.Len() == 0 || p > priority || p == priority && !q.lifo {
+			continue
+		}
+		if target == nil || p < lowest {
+			target, lowest = l, p
+		}
+	}
+	if target == nil {
+		return false
+	}
+
+	elem := target.memory.Back()
+	request := elem.Value.(*Request)
+	if err := q.writeDisk(target, lowest, request, true); err != nil {
+		RequestLogger(q.Logger, request).Errorw("Request写入磁盘失败", "error", err)
+		return false
+	}
+	target.memory.Remove(elem)
+	q.memoryLen--
+	return true
+}
+
+func (q *BaseDiskOverflowPriorityQueue) Push(value any, priority int) {
+	request, ok := value.(*Request)
+	if !ok {
+		q.Logger.Fatalw("磁盘溢出队列只能保存Request", "type", value)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer q.updateStats()
+	q.Stats.IncValue("queue/push", 1, 0)
+
+	l := q.level(priority)
+	// FIFO时磁盘中已有同优先级的Request，新的Request只能排在其后
+	if !q.lifo && l.diskSize > 0 {
+		if err := q.writeDisk(l, priority, request, false); err != nil {
+			RequestLogger(q.Logger, request).Errorw("Request写入磁盘失败", "error", err)
+		}
+		return
+	}
+	// 内存已满且没有更低优先级的Request可以溢出时直接写入磁盘
+	if q.memorySize > 0 && q.memoryLen >= q.memorySize && !q.spill(priority) {
+		if err := q.writeDisk(l, priority, request, q.lifo); err != nil {
+			RequestLogger(q.Logger, request).Errorw("Request写入磁盘失败", "error", err)
+		}
+		return
+	}
+
+	if q.lifo {
+		l.memory.PushFront(request)
+	} else {
+		l.memory.PushBack(request)
+	}
+	q.memoryLen++
+	q.Stats.IncValue("queue/memory/push", 1, 0)
+}
+
+// top 优先级最高的非空队列
+func (q *BaseDiskOverflowPriorityQueue) top() *overflowLevel {
+	var res *overflowLevel
+	best := 0
+	for p, l := range q.levels {
+		if l.memory.Len()+l.diskSize > 0 && (res == nil || p > best) {
+			res, best = l, p
+		}
+	}
+	return res
+}
+
+func (q *BaseDiskOverflowPriorityQueue) Pop() any {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer q.updateStats()
+
+	l := q.top()
+	if l == nil {
+		return nil
+	}
+	q.Stats.IncValue("queue/pop", 1, 0)
+	if elem := l.memory.Front(); elem != nil {
+		l.memory.Remove(elem)
+		q.memoryLen--
+		q.Stats.IncValue("queue/memory/pop", 1, 0)
+		return elem.Value.(*Request)
+	}
+	request, err := q.readDisk(l, true)
+	if err != nil {
+		q.Logger.Errorw("从磁盘读取Request失败", "error", err)
+		return nil
+	}
+	return request
+}
+
+func (q *BaseDiskOverflowPriorityQueue) Peek() any {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	l := q.top()
+	if l == nil {
+		return nil
+	}
+	q.Stats.IncValue("queue/peek", 1, 0)
+	if elem := l.memory.Front(); elem != nil {
+		return elem.Value.(*Request)
+	}
+	request, err := q.readDisk(l, false)
+	if err != nil {
+		q.Logger.Errorw("从磁盘读取Request失败", "error", err)
+		return nil
+	}
+	return request
+}
+
+func (q *BaseDiskOverflowPriorityQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.memoryLen + q.diskLen
+}
+
+// updateStats 记录内存和磁盘中的Request数量
+func (q *BaseDiskOverflowPriorityQueue) updateStats() {
+	q.Stats.SetValue("queue/memory/size", q.memoryLen)
+	q.Stats.SetValue("queue/disk/size", q.diskLen)
+	q.Stats.MaxValue("queue/memory/max_size", q.memoryLen)
+	q.Stats.MaxValue("queue/disk/max_size", q.diskLen)
+}
+
+func (q *BaseDiskOverflowPriorityQueue) Close(spider *Spider) {
+	q.mu.Lock()
+	for _, l := range q.levels {
+		for _, segment := range l.segments {
+			_ = segment.f.Close()
+		}
+	}
+	if q.diskLen > 0 {
+		q.Logger.Warnw("磁盘中仍有未处理的Request，将被删除", "count", q.diskLen)
+	}
+	q.mu.Unlock()
+	if err := os.RemoveAll(q.dir); err != nil {
+		q.Logger.Errorw("删除队列目录失败", "dir", q.dir, "error", err)
+	}
+	q.BaseSpiderModule.Close(spider)
+}
+
+// FIFODiskOverflowPriorityQueue 优先级相同时 FIFO
+type FIFODiskOverflowPriorityQueue struct {
+	BaseDiskOverflowPriorityQueue
+}
+
+func (q *FIFODiskOverflowPriorityQueue) Name() string {
+	return "FIFODiskOverflowPriorityQueue"
+}
+
+func (q *FIFODiskOverflowPriorityQueue) FromSpider(spider *Spider) {
+	q.init(spider, q.Name(), false)
+}
+
+// LIFODiskOverflowPriorityQueue 优先级相同时 LIFO
+type LIFODiskOverflowPriorityQueue struct {
+	BaseDiskOverflowPriorityQueue
+}
+
+func (q *LIFODiskOverflowPriorityQueue) Name() string {
+	return "LIFODiskOverflowPriorityQueue"
+}
+
+func (q *LIFODiskOverflowPriorityQueue) FromSpider(spider *Spider) {
+	q.init(spider, q.Name(), true)
+}
@@ -0,0 +1,188 @@
+package xspider
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 代理池选择代理的方式
+const (
+	ProxyModeRoundRobin = "round_robin"
+	ProxyModeRandom     = "random"
+	ProxyModeLeastUsed  = "least_used"
+)
+
+// proxyState 代理池中单个代理的状态
+type proxyState struct {
+	url         string
+	auth        string
+	failures    int
+	used        int
+	bannedUntil time.Time
+}
+
+type proxyPool struct {
+	proxies []*proxyState
+	mode    string
+	index   int
+	mu      sync.Mutex
+}
+
+func newProxyPool(proxies []string, mode string) (*proxyPool, error) {
+	switch mode {
+	case ProxyModeRoundRobin, ProxyModeRandom, ProxyModeLeastUsed:
+	default:
+		return nil, fmt.Errorf("invalid proxy mode: %s", mode)
+	}
+
+	pool := &proxyPool{mode: mode}
+	seen := make(map[string]bool)
+	for _, v := range proxies {
+		proxy, auth, err := parseProxy(v)
+		if err != nil {
+			return nil, err
+		}
+		if seen[proxy] {
+			continue
+		}
+		seen[proxy] = true
+		pool.proxies = append(pool.proxies, &proxyState{url: proxy, auth: auth})
+	}
+	return pool, nil
+}
+
+// parseProxy 拆分HTTP代理地址中的认证信息，返回不含认证信息的代理地址和Proxy-Authorization的值
+func parseProxy(proxy string) (string, string, error) {
+	u, err := url.Parse(strings.TrimSpace(proxy))
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", "", fmt.Errorf("invalid proxy: %s", proxy)
+	}
+	// socks5代理的认证信息在握手时发送，保留在代理地址中
+	if u.User == nil || u.Scheme == "socks5" || u.Scheme == "socks5h" {
+		return u.String(), "", nil
+	}
+	password, _ := u.User.Password()
+	auth := BasicAuthHeader(u.User.Username(), password, "")
+	u.User = nil
+	return u.String(), auth, nil
+}
+
+// redactProxy 隐藏代理地址中的密码，用于日志输出
+func redactProxy(proxy string) string {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return proxy
+	}
+	return u.Redacted()
+}
+
+// readProxyFile 读取代理列表文件，每行一个代理，忽略空行和#开头的注释
+func readProxyFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var proxies []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		proxies = append(proxies, line)
+	}
+	return proxies, scanner.Err()
+}
+
+func (p *proxyPool) Len() int {
+	return len(p.proxies)
+}
+
+// Get 按选择方式获取一个可用的代理，所有代理都被隔离时返回最早解除隔离的代理
+func (p *proxyPool) Get() *proxyState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.proxies) == 0 {
+		return nil
+	}
+	now := time.Now()
+	var available []*proxyState
+	for _, v := range p.proxies {
+		if !v.bannedUntil.After(now) {
+			available = append(available, v)
+		}
+	}
+
+	var res *proxyState
+	switch {
+	case len(available) == 0:
+		for _, v := range p.proxies {
+			if res == nil || v.bannedUntil.Before(res.bannedUntil) {
+				res = v
+			}
+		}
+	case p.mode == ProxyModeRandom:
+		res = available[rand.Intn(len(available))]
+	case p.mode == ProxyModeLeastUsed:
+		for _, v := range available {
+			if res == nil || v.used < res.used {
+				res = v
+			}
+		}
+	default:
+		res = available[p.index%len(available)]
+		p.index++
+	}
+	res.used++
+	return res
+}
+
+// Find 根据代理地址查找代理
+func (p *proxyPool) Find(proxy string) *proxyState {
+	for _, v := range p.proxies {
+		if v.url == proxy {
+			return v
+		}
+	}
+	return nil
+}
+
+// Success 代理请求成功，清空连续失败次数
+func (p *proxyPool) Success(proxy *proxyState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proxy.failures = 0
+}
+
+// Failure 记录代理的连续失败次数，达到maxFailures时隔离banTime，返回是否被隔离
+func (p *proxyPool) Failure(proxy *proxyState, maxFailures int, banTime time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proxy.failures++
+	if proxy.failures < maxFailures {
+		return false
+	}
+	proxy.failures = 0
+	proxy.bannedUntil = time.Now().Add(banTime)
+	return true
+}
+
+// Ban 立即隔离代理banTime
+func (p *proxyPool) Ban(proxy *proxyState, banTime time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proxy.failures = 0
+	proxy.bannedUntil = time.Now().Add(banTime)
+}
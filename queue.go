@@ -49,11 +49,8 @@ func (q *BasePriorityQueue) Pop() any {
 }
 
 func (q *BasePriorityQueue) Peek() any {
-	if q.queue.Empty() {
-		return nil
-	}
-	values := q.queue.Values()
-	if len(values) == 0 {
+	value, ok := q.queue.Peek()
+	if !ok {
 		return nil
 	}
 
@@ -61,7 +58,7 @@ func (q *BasePriorityQueue) Peek() any {
 		q.Stats.IncValue("queue/peek", 1, 0)
 	}
 
-	return values[0].(*queueElement).value
+	return value.(*queueElement).value
 }
 
 func (q *BasePriorityQueue) Len() int {
@@ -81,31 +78,31 @@ func (q *FIFOPriorityQueue) FromSpider(spider *Spider) {
 	InitBaseSpiderModule(&q.BasePriorityQueue.BaseSpiderModule, spider, q.Name()) // 调用基类初始化 Logger/Stats
 
 	// 初始化内部队列
-	compare := func(a, b interface{}) int {
-		ae := a.(*queueElement)
-		be := b.(*queueElement)
-
-		// 优先级高者优先
-		if ae.priority > be.priority {
-			return -1
-		} else if ae.priority < be.priority {
-			return 1
-		}
-
-		// 优先级相同：timestamp 小的在前（FIFO）
-		if ae.timestamp < be.timestamp {
-			return -1
-		} else if ae.timestamp > be.timestamp {
-			return 1
-		}
-		return 0
-	}
-
-	q.queue = priorityqueue.NewWith(compare)
+	q.queue = priorityqueue.NewWith(fifoCompare)
 	q.counter = 0
 	q.Logger.Info("模块初始化完成")
 }
 
+func fifoCompare(a, b interface{}) int {
+	ae := a.(*queueElement)
+	be := b.(*queueElement)
+
+	// 优先级高者优先
+	if ae.priority > be.priority {
+		return -1
+	} else if ae.priority < be.priority {
+		return 1
+	}
+
+	// 优先级相同：timestamp 小的在前（FIFO）
+	if ae.timestamp < be.timestamp {
+		return -1
+	} else if ae.timestamp > be.timestamp {
+		return 1
+	}
+	return 0
+}
+
 // LIFOPriorityQueue 优先级相同时 LIFO
 type LIFOPriorityQueue struct {
 	BasePriorityQueue
@@ -118,26 +115,26 @@ func (q *LIFOPriorityQueue) Name() string {
 func (q *LIFOPriorityQueue) FromSpider(spider *Spider) {
 	InitBaseSpiderModule(&q.BasePriorityQueue.BaseSpiderModule, spider, q.Name())
 
-	compare := func(a, b interface{}) int {
-		ae := a.(*queueElement)
-		be := b.(*queueElement)
-
-		if ae.priority > be.priority {
-			return -1
-		} else if ae.priority < be.priority {
-			return 1
-		}
-
-		// 优先级相同：timestamp 大的在前（LIFO）
-		if ae.timestamp > be.timestamp {
-			return -1
-		} else if ae.timestamp < be.timestamp {
-			return 1
-		}
-		return 0
-	}
-
-	q.queue = priorityqueue.NewWith(compare)
+	q.queue = priorityqueue.NewWith(lifoCompare)
 	q.counter = 0
 	q.Logger.Info("模块初始化完成")
 }
+
+func lifoCompare(a, b interface{}) int {
+	ae := a.(*queueElement)
+	be := b.(*queueElement)
+
+	if ae.priority > be.priority {
+		return -1
+	} else if ae.priority < be.priority {
+		return 1
+	}
+
+	// 优先级相同：timestamp 大的在前（LIFO）
+	if ae.timestamp > be.timestamp {
+		return -1
+	} else if ae.timestamp < be.timestamp {
+		return 1
+	}
+	return 0
+}
@@ -6,12 +6,15 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 
 	"github.com/xue0228/xspider/container"
 	"github.com/xue0228/xspider/encoder"
+	"golang.org/x/net/publicsuffix"
 )
 
 type Request struct {
@@ -55,7 +58,7 @@ func (r *Request) HttpRepr() []byte {
 	buf.WriteString(" HTTP/1.1\r\n")
 
 	buf.WriteString("Host: ")
-	buf.WriteString(r.Domain())
+	buf.WriteString(r.Url.Host)
 	buf.WriteString("\r\n")
 
 	buf.WriteString(HeaderToString(*r.Headers))
@@ -111,30 +114,96 @@ func (r *Request) Fingerprint(includeHeaders []string, keepFragments bool) strin
 	return hex.EncodeToString(o.Sum(nil))
 }
 
+// getDomain 获取url的注册域名（eTLD+1），IP、localhost等没有公共后缀的主机直接返回主机名
 func getDomain(u *url.URL) string {
-	host := u.Hostname()
-	list := strings.Split(host, ".")
-	length := len(list)
-	if length >= 2 {
-		return list[length-2] + "." + list[length-1]
-	} else if length == 1 {
-		return list[0]
-	} else {
+	host := getHost(u)
+	if host == "unknown" || net.ParseIP(host) != nil {
+		return host
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+// getHost 获取url的主机名，统一为小写
+func getHost(u *url.URL) string {
+	if u == nil {
+		return "unknown"
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
 		return "unknown"
 	}
+	return host
 }
 
+// Domain 获取Request所属的注册域名，Ctx中设置了domain时优先使用
 func (r *Request) Domain() string {
-	if d, err := container.Get[string](r.Ctx, "domain"); err != nil {
-		return d
-	} else {
-		d := getDomain(r.Url)
-		container.Set(r.Ctx, "domain", d)
+	if d, err := container.Get[string](r.Ctx, "domain"); err == nil && d != "" {
 		return d
 	}
+	return getDomain(r.Url)
+}
+
+// Host 获取Request的主机名，统一为小写
+func (r *Request) Host() string {
+	return getHost(r.Url)
+}
+
+// SlotKey 获取Request所属的下载slot，Ctx中设置了download_slot时优先使用，否则使用Domain
+func (r *Request) SlotKey() string {
+	if s, err := container.Get[string](r.Ctx, "download_slot"); err == nil && s != "" {
+		return s
+	}
+	return r.Domain()
 }
 
+// Copy 复制Request，Url、Headers、Body、Cookies及Ctx均为深拷贝
+func (r *Request) Copy() *Request {
+	var u *url.URL
+	if r.Url != nil {
+		uu := *r.Url
+		u = &uu
+	}
+	var headers *http.Header
+	if r.Headers != nil {
+		h := r.Headers.Clone()
+		headers = &h
+	}
+	var body io.Reader
+	if r.Body != nil {
+		body = bytes.NewBuffer(ReadRequestBody(r))
+	}
+	var ctx container.JsonMap
+	if r.Ctx != nil {
+		ctx = r.Ctx.Copy()
+	}
+	return &Request{
+		Url:        u,
+		Method:     r.Method,
+		Headers:    headers,
+		Body:       body,
+		Cookies:    slices.Clone(r.Cookies),
+		Encoding:   r.Encoding,
+		Priority:   r.Priority,
+		DontFilter: r.DontFilter,
+		Ctx:        ctx,
+		Errback:    r.Errback,
+		Callback:   r.Callback,
+	}
+}
+
+// ToRequestTable 转换为RequestTable，Data字段保存ToJsonMap的完整内容，Url、Method、Body等字段便于查询。
+// 序列化失败时Data为空，从表中取出时只能根据Url、Method和Body重建
 func (r *Request) ToRequestTable() *RequestTable {
+	rt, _ := r.toRequestTable()
+	return rt
+}
+
+// toRequestTable 转换为RequestTable，同时返回序列化Data时的错误
+func (r *Request) toRequestTable() (*RequestTable, error) {
 	var u, method, body string
 	if r.Url != nil {
 		u = r.Url.String()
@@ -148,12 +217,19 @@ func (r *Request) ToRequestTable() *RequestTable {
 	} else {
 		body = ""
 	}
-	return &RequestTable{
-		Body:   body,
-		Method: method,
-		Url:    u,
-		Fp:     r.Fingerprint(nil, false),
+	rt := &RequestTable{
+		Body:     body,
+		Method:   method,
+		Url:      u,
+		Fp:       r.Fingerprint(nil, false),
+		Priority: r.Priority,
+	}
+	data, err := r.ToJsonMap().Dumps()
+	if err != nil {
+		return rt, err
 	}
+	rt.Data = string(data)
+	return rt, nil
 }
 
 func (r *Request) ToJsonMap() container.JsonMap {
@@ -168,6 +244,10 @@ func (r *Request) ToJsonMap() container.JsonMap {
 	headerDict := make(map[string][]string)
 	if r.Headers != nil {
 		for k, v := range *r.Headers {
+			// 代理认证信息不写入磁盘队列和RequestTable，下载前由HttpProxyDownloaderMiddleware重新设置
+			if http.CanonicalHeaderKey(k) == "Proxy-Authorization" {
+				continue
+			}
 			headerDict[k] = v
 		}
 	}
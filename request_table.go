@@ -2,101 +2,408 @@ package xspider
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/glebarez/sqlite"
 	"github.com/xue0228/xspider/container"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
+// RequestTable中Request的状态
+const (
+	RequestStatusPending uint8 = iota // 等待处理
+	RequestStatusRunning              // 正在处理
+	RequestStatusDone                 // 处理完成
+	RequestStatusDropped              // 已丢弃或处理出错
+)
+
 type RequestTable struct {
 	gorm.Model
-	Url    string
-	Method string `gorm:"default:'GET'"`
-	Body   string `gorm:"default:''"`
-	Fp     string `gorm:"type:varchar(40);uniqueIndex;not null"`
-	Status uint8  `gorm:"default:0"`
+	Url      string
+	Method   string `gorm:"default:'GET'"`
+	Body     string `gorm:"default:''"`
+	Fp       string `gorm:"type:varchar(40);uniqueIndex;not null"`
+	Priority int    `gorm:"default:0;index"`
+	Status   uint8  `gorm:"default:0;index"`
+	// Data Request.ToJsonMap序列化后的完整内容，包括请求头、Cookies、回调函数、DontFilter和Ctx等，
+	// 取出时以Data为准，只有Priority使用字段中的值，便于直接修改数据库调整优先级；
+	// Url、Method、Body只用于查询，Fp、Status等由表维护，不会写回Request。
+	// 旧版本创建的表由AutoMigrate自动添加该字段，原有记录的Data为空，取出时根据Url、Method和Body重建，其他属性使用默认值
+	Data string `gorm:"type:text"`
+	// WorkerId 正在处理或已处理该Request的worker
+	WorkerId string `gorm:"type:varchar(64);default:'';index"`
+	// LeaseUntil 正在处理状态的租约到期时间，到期后可被其他worker重新取出
+	LeaseUntil *time.Time `gorm:"index"`
+	// Retries 租约过期后被重新取出的次数
+	Retries int `gorm:"default:0"`
+}
+
+// OpenRequestTableDB 根据数据库类型打开数据库，dialect可以是sqlite、mysql、postgres，
+// sqlite使用纯Go实现的驱动，dsn为数据库文件路径
+func OpenRequestTableDB(dialect, dsn string) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch dialect {
+	case "sqlite", "sqlite3":
+		dialector = sqlite.Open(dsn)
+	case "mysql":
+		dialector = mysql.Open(dsn)
+	case "postgres", "postgresql":
+		dialector = postgres.Open(dsn)
+	default:
+		return nil, fmt.Errorf("unsupported request table dialect: %s", dialect)
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Error), // 只输出警告和错误日志
+	})
+	if err != nil {
+		return nil, err
+	}
+	if dialector.Name() == "sqlite" {
+		// sqlite不支持并发写入，使用单个连接避免database is locked错误
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
+
+// DefaultWorkerId 根据主机名和进程号生成worker id，附加随机数避免进程号重复
+func DefaultWorkerId() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	id := fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+	if len(id) > 64 {
+		id = id[len(id)-64:]
+	}
+	return id
 }
 
-type SqliteRequestTable struct {
+// GormRequestTable 保存在数据库中的Request表，Fp字段唯一，重复的Request无法加入。
+// 多个进程可以共用同一个表：Pop原子地取出Request并记录WorkerId和租约到期时间，
+// 处理期间需要定期调用Renew延长租约，租约过期的Request由ReclaimExpired重新设置为等待处理
+type GormRequestTable struct {
 	db    *gorm.DB
 	table string
+	// Fingerprinter 计算Fp字段使用的指纹，NewGormRequestTable默认使用默认设置的RequestFingerprinterImpl，
+	// NewGormRequestTableFromSettings使用由设置项创建的RequestFingerprinterImpl，与使用相同设置的Spider去重规则一致
+	Fingerprinter RequestFingerprinter
+	// WorkerId 当前worker的唯一标识，默认为DefaultWorkerId()
+	WorkerId string
+	// Lease 取出Request时的租约时长
+	Lease time.Duration
+	// MaxRetries 租约过期后最多重新取出的次数，超过后设置为已丢弃，0表示不限制
+	MaxRetries int
+	counter    atomic.Int64
 }
 
-func NewSqliteRequestTable(dsn, table string) *SqliteRequestTable {
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Error), // 只输出警告和错误日志
-	})
+// SqliteRequestTable 兼容旧版本的名称
+//
+// Deprecated: 使用GormRequestTable
+type SqliteRequestTable = GormRequestTable
+
+// NewGormRequestTable 打开数据库中的Request表，表不存在时自动创建
+func NewGormRequestTable(dialect, dsn, table string) (*GormRequestTable, error) {
+	db, err := OpenRequestTableDB(dialect, dsn)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	if err = db.Table(table).AutoMigrate(&RequestTable{}); err != nil {
+		// 多个worker同时创建表时可能冲突，重试一次
+		if err = db.Table(table).AutoMigrate(&RequestTable{}); err != nil {
+			return nil, err
+		}
 	}
-	err = db.Table(table).AutoMigrate(&RequestTable{})
+	return &GormRequestTable{
+		db:            db,
+		table:         table,
+		Fingerprinter: NewRequestFingerprinter(container.NewSyncJsonMap()),
+		WorkerId:      DefaultWorkerId(),
+		Lease:         5 * time.Minute,
+		MaxRetries:    3,
+	}, nil
+}
+
+// NewGormRequestTableFromSettings 根据Spider的设置项打开Request表，
+// 数据库由REQUEST_TABLE_DIALECT、REQUEST_TABLE_DSN和REQUEST_TABLE_NAME设置，
+// 同时读取REQUEST_TABLE_WORKER_ID、REQUEST_TABLE_LEASE、REQUEST_TABLE_MAX_RETRIES及REQUEST_FINGERPRINTER_*，
+// 用于在Spider之外读写与Spider相同的表
+func NewGormRequestTableFromSettings(settings container.JsonMap) (*GormRequestTable, error) {
+	botName := container.GetWithDefault[string](settings, "BOT_NAME", "xbot")
+	dialect := container.GetWithDefault[string](settings, "REQUEST_TABLE_DIALECT", "sqlite")
+	// 未设置REQUEST_TABLE_DSN时使用BOT_NAME_requests.db
+	dsn := container.GetWithDefault[string](settings, "REQUEST_TABLE_DSN", "")
+	if dsn == "" {
+		dsn = botName + "_requests.db"
+	}
+	name := container.GetWithDefault[string](settings, "REQUEST_TABLE_NAME", "requests")
+	t, err := NewGormRequestTable(dialect, dsn, name)
+	if err != nil {
+		return nil, err
+	}
+	t.Fingerprinter = NewRequestFingerprinter(settings)
+	// 未设置REQUEST_TABLE_WORKER_ID时使用DefaultWorkerId()
+	if workerId := container.GetWithDefault[string](settings, "REQUEST_TABLE_WORKER_ID", ""); workerId != "" {
+		t.WorkerId = workerId
+	}
+	t.Lease = time.Duration(container.GetWithDefault[int](settings, "REQUEST_TABLE_LEASE", 300)) * time.Second
+	t.MaxRetries = container.GetWithDefault[int](settings, "REQUEST_TABLE_MAX_RETRIES", t.MaxRetries)
+	return t, nil
+}
+
+func mustNewGormRequestTable(dialect, dsn, table string) *GormRequestTable {
+	t, err := NewGormRequestTable(dialect, dsn, table)
 	if err != nil {
 		panic(err)
 	}
-	return &SqliteRequestTable{db: db, table: table}
+	return t
+}
+
+// NewSqliteRequestTable 打开sqlite数据库中的Request表，dsn为数据库文件路径
+func NewSqliteRequestTable(dsn, table string) *GormRequestTable {
+	return mustNewGormRequestTable("sqlite", dsn, table)
+}
+
+// NewMysqlRequestTable 打开mysql数据库中的Request表
+func NewMysqlRequestTable(dsn, table string) *GormRequestTable {
+	return mustNewGormRequestTable("mysql", dsn, table)
 }
 
-func (t *SqliteRequestTable) Add(request *Request) (uint, error) {
-	rt := request.ToRequestTable()
-	result := t.db.Table(t.table).Create(rt)
+// NewPostgresRequestTable 打开postgres数据库中的Request表
+func NewPostgresRequestTable(dsn, table string) *GormRequestTable {
+	return mustNewGormRequestTable("postgres", dsn, table)
+}
+
+// Add 将Request加入表中，Fp已存在时返回ErrRequestTableDuplicate。
+// DontFilter为true的Request使用唯一的Fp，总能加入
+func (t *GormRequestTable) Add(request *Request) (uint, error) {
+	rt, err := request.toRequestTable()
+	if err != nil {
+		return 0, err
+	}
+	rt.Fp = t.Fingerprinter.Fingerprint(request)
+	if request.DontFilter {
+		sum := sha1.Sum([]byte(rt.Fp + strconv.FormatInt(time.Now().UnixNano(), 10) + strconv.FormatInt(t.counter.Add(1), 10)))
+		rt.Fp = hex.EncodeToString(sum[:])
+	}
+	result := t.db.Table(t.table).Clauses(clause.OnConflict{DoNothing: true}).Create(rt)
 	if result.Error != nil {
 		return 0, result.Error
 	}
+	if result.RowsAffected == 0 {
+		return 0, ErrRequestTableDuplicate
+	}
 	return rt.ID, nil
 }
 
-func (t *SqliteRequestTable) Done(request *Request) (uint, error) {
+// requestId 从Pop取出的Request的Ctx中获取其在表中的id，Request不属于该表时返回false
+func (t *GormRequestTable) requestId(request *Request) (uint, bool) {
+	if request == nil || request.Ctx == nil {
+		return 0, false
+	}
+	if table, err := container.Get[string](request.Ctx, "table"); err != nil || table != t.table {
+		return 0, false
+	}
+	id, err := container.Get[uint](request.Ctx, "id")
+	return id, err == nil
+}
+
+// setRequestStatus 更新当前worker正在处理的Request的状态，租约已被其他worker取得时返回ErrRequestTableLeaseLost
+func (t *GormRequestTable) setRequestStatus(request *Request, status uint8) (uint, error) {
 	id, err := container.Get[uint](request.Ctx, "id")
 	if err != nil {
 		return 0, err
 	}
-	result := t.db.Table(t.table).Where("id = ?", id).Update("status", 2)
+	result := t.db.Table(t.table).
+		Where("id = ? AND status = ? AND worker_id = ?", id, RequestStatusRunning, t.WorkerId).
+		Updates(map[string]any{"status": status, "lease_until": nil})
 	if result.Error != nil {
 		return id, result.Error
 	}
+	if result.RowsAffected == 0 {
+		// 同一Request可能先后触发丢弃和Errback信号，已由当前worker处理完成时不视为租约丢失
+		var rt RequestTable
+		err = t.db.Table(t.table).Select("status", "worker_id").Where("id = ?", id).Take(&rt).Error
+		if err == nil && rt.WorkerId == t.WorkerId && (rt.Status == RequestStatusDone || rt.Status == RequestStatusDropped) {
+			return id, nil
+		}
+		return id, ErrRequestTableLeaseLost
+	}
 	return id, nil
 }
 
-func (t *SqliteRequestTable) Drop(request *Request) (uint, error) {
-	id, err := container.Get[uint](request.Ctx, "id")
+func (t *GormRequestTable) Done(request *Request) (uint, error) {
+	return t.setRequestStatus(request, RequestStatusDone)
+}
+
+func (t *GormRequestTable) Drop(request *Request) (uint, error) {
+	return t.setRequestStatus(request, RequestStatusDropped)
+}
+
+// Pop 按优先级从高到低、id从小到大原子地取出一个等待处理的Request，将其状态设置为正在处理并记录租约，
+// 没有等待处理的Request时返回gorm.ErrRecordNotFound
+func (t *GormRequestTable) Pop() (*Request, error) {
+	rt, err := t.claim()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	result := t.db.Table(t.table).Where("id = ?", id).Update("status", 3)
-	if result.Error != nil {
-		return id, result.Error
+	return t.fromRequestTable(rt)
+}
+
+// claim 取出一行并设置租约。sqlite和postgres使用UPDATE ... RETURNING，
+// postgres和mysql在选择时使用FOR UPDATE SKIP LOCKED跳过其他worker正在取出的行
+func (t *GormRequestTable) claim() (*RequestTable, error) {
+	values := map[string]any{
+		"status":      RequestStatusRunning,
+		"worker_id":   t.WorkerId,
+		"lease_until": time.Now().Add(t.Lease),
+	}
+	skipLocked := clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}
+	pending := func(tx *gorm.DB) *gorm.DB {
+		return tx.Table(t.table).
+			Where("status = ? AND deleted_at IS NULL", RequestStatusPending).
+			Order("priority DESC").Order("id ASC").
+			Limit(1)
+	}
+
+	switch t.db.Dialector.Name() {
+	case "mysql":
+		// mysql不支持RETURNING，在事务中锁定后更新
+		var rt RequestTable
+		err := t.db.Transaction(func(tx *gorm.DB) error {
+			if err := pending(tx).Clauses(skipLocked).Find(&rt).Error; err != nil {
+				return err
+			}
+			if rt.ID == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return tx.Table(t.table).Where("id = ?", rt.ID).Updates(values).Error
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &rt, nil
+	default:
+		sub := pending(t.db).Select("id")
+		if t.db.Dialector.Name() == "postgres" {
+			sub = sub.Clauses(skipLocked)
+		}
+		var rows []RequestTable
+		result := t.db.Table(t.table).Model(&rows).Clauses(clause.Returning{}).Where("id = (?)", sub).Updates(values)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if len(rows) == 0 {
+			return nil, gorm.ErrRecordNotFound
+		}
+		return &rows[0], nil
 	}
-	return id, nil
 }
 
-func (t *SqliteRequestTable) Pop() (*Request, error) {
-	var rt RequestTable
-	result := t.db.Table(t.table).Where("status = ?", 0).First(&rt)
-	if result.Error != nil {
-		return nil, result.Error
+// Renew 延长当前worker所有正在处理的Request的租约，返回延长的数量
+func (t *GormRequestTable) Renew() (int64, error) {
+	result := t.db.Table(t.table).
+		Where("status = ? AND worker_id = ?", RequestStatusRunning, t.WorkerId).
+		Update("lease_until", time.Now().Add(t.Lease))
+	return result.RowsAffected, result.Error
+}
+
+// ReclaimExpired 将租约已过期的Request重新设置为等待处理并增加重试次数，
+// 重试次数达到MaxRetries的设置为已丢弃，返回重新设置为等待处理的数量。
+// 旧版本表中正在处理的记录没有租约（LeaseUntil为NULL），同样视为已过期
+func (t *GormRequestTable) ReclaimExpired() (int64, error) {
+	now := time.Now()
+	if t.MaxRetries > 0 {
+		result := t.db.Table(t.table).
+			Where("status = ? AND (lease_until < ? OR lease_until IS NULL) AND retries >= ?", RequestStatusRunning, now, t.MaxRetries).
+			Updates(map[string]any{"status": RequestStatusDropped, "lease_until": nil})
+		if result.Error != nil {
+			return 0, result.Error
+		}
 	}
-	result = t.db.Table(t.table).Where("id = ?", rt.ID).Update("status", 1)
-	if result.Error != nil {
-		return nil, result.Error
-	}
-	ctx := container.NewSyncJsonMap()
-	container.Set(ctx, "id", rt.ID)
-	container.Set(ctx, "table", t.table)
-	var body io.Reader
-	if rt.Body != "" {
-		data, err := base64.StdEncoding.DecodeString(rt.Body)
+	result := t.db.Table(t.table).
+		Where("status = ? AND (lease_until < ? OR lease_until IS NULL)", RequestStatusRunning, now).
+		Updates(map[string]any{
+			"status":      RequestStatusPending,
+			"worker_id":   "",
+			"lease_until": nil,
+			"retries":     gorm.Expr("retries + 1"),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// Release 将当前worker正在处理的Request重新设置为等待处理，不增加重试次数，用于正常关闭时归还未完成的Request
+func (t *GormRequestTable) Release() (int64, error) {
+	result := t.db.Table(t.table).
+		Where("status = ? AND worker_id = ?", RequestStatusRunning, t.WorkerId).
+		Updates(map[string]any{"status": RequestStatusPending, "worker_id": "", "lease_until": nil})
+	return result.RowsAffected, result.Error
+}
+
+// fromRequestTable 根据表中的记录重建Request，字段与Data的优先级见RequestTable.Data
+func (t *GormRequestTable) fromRequestTable(rt *RequestTable) (*Request, error) {
+	var request *Request
+	if rt.Data != "" {
+		r, err := decodeRequest([]byte(rt.Data))
 		if err != nil {
 			return nil, err
 		}
-		body = bytes.NewBuffer(data)
+		request = r
+		request.Priority = rt.Priority
+	} else {
+		// 旧版本的记录只有Url、Method和Body
+		var body io.Reader
+		if rt.Body != "" {
+			data, err := base64.StdEncoding.DecodeString(rt.Body)
+			if err != nil {
+				return nil, err
+			}
+			body = bytes.NewBuffer(data)
+		}
+		request = NewRequest(rt.Url, WithMethod(rt.Method), WithBody(body), WithPriority(rt.Priority))
 	}
-	return NewRequest(rt.Url, WithMethod(rt.Method), WithBody(body), WithCtx(ctx)), nil
+	container.Set(request.Ctx, "id", rt.ID)
+	container.Set(request.Ctx, "table", t.table)
+	return request, nil
+}
+
+// HasPending 是否有等待处理的Request，其他worker正在处理的Request完成前可能产生新的Request，也视为等待处理
+func (t *GormRequestTable) HasPending() (bool, error) {
+	var ids []uint
+	result := t.db.Table(t.table).
+		Where("status = ? OR (status = ? AND worker_id <> ?)", RequestStatusPending, RequestStatusRunning, t.WorkerId).
+		Limit(1).Pluck("id", &ids)
+	return len(ids) > 0, result.Error
 }
 
-func (t *SqliteRequestTable) SetStatus(old, new uint8) error {
+// Count 指定状态的Request数量
+func (t *GormRequestTable) Count(status uint8) (int64, error) {
+	var count int64
+	result := t.db.Table(t.table).Where("status = ?", status).Count(&count)
+	return count, result.Error
+}
+
+// SetStatus 将所有指定状态的Request设置为新的状态，不检查租约，多个worker共用表时应使用ReclaimExpired
+func (t *GormRequestTable) SetStatus(old, new uint8) error {
 	result := t.db.Table(t.table).Where("status = ?", old).Update("status", new)
 	if result.Error != nil {
 		return result.Error
@@ -104,12 +411,22 @@ func (t *SqliteRequestTable) SetStatus(old, new uint8) error {
 	return nil
 }
 
-func (t *SqliteRequestTable) Generator(resetRunning bool) Results {
+// Close 关闭数据库连接
+func (t *GormRequestTable) Close() error {
+	sqlDB, err := t.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// Generator 依次取出表中等待处理的Request，reclaimExpired为true时先回收租约已过期的Request
+func (t *GormRequestTable) Generator(reclaimExpired bool) Results {
 	c := make(chan any)
 	go func() {
 		defer close(c)
-		if resetRunning {
-			_ = t.SetStatus(1, 0)
+		if reclaimExpired {
+			_, _ = t.ReclaimExpired()
 		}
 		for {
 			request, err := t.Pop()
@@ -121,3 +438,8 @@ func (t *SqliteRequestTable) Generator(resetRunning bool) Results {
 	}()
 	return c
 }
+
+// isNotFound 判断是否为没有记录的错误
+func isNotFound(err error) bool {
+	return errors.Is(err, gorm.ErrRecordNotFound)
+}
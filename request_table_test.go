@@ -0,0 +1,464 @@
+package xspider
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xue0228/xspider/container"
+	"gorm.io/gorm"
+)
+
+// newTestRequestTable 创建使用内存sqlite数据库的Request表，测试结束时自动关闭
+func newTestRequestTable(t *testing.T) *GormRequestTable {
+	t.Helper()
+	table, err := NewGormRequestTable("sqlite", ":memory:", "requests")
+	if err != nil {
+		t.Fatalf("NewGormRequestTable() error = %v", err)
+	}
+	t.Cleanup(func() { _ = table.Close() })
+	return table
+}
+
+func TestGormRequestTableAdd(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests []*Request
+		wantErrs []error
+	}{
+		{"Single request", []*Request{
+			NewRequest("https://example.com/a"),
+		}, []error{nil}},
+		{"Duplicate url", []*Request{
+			NewRequest("https://example.com/a"),
+			NewRequest("https://example.com/a"),
+		}, []error{nil, ErrRequestTableDuplicate}},
+		{"Duplicate after canonicalization", []*Request{
+			NewRequest("https://example.com/a?x=1&y=2"),
+			NewRequest("HTTPS://EXAMPLE.COM:443/a?y=2&x=1"),
+		}, []error{nil, ErrRequestTableDuplicate}},
+		{"Different method", []*Request{
+			NewRequest("https://example.com/a"),
+			NewRequest("https://example.com/a", WithMethod("POST")),
+		}, []error{nil, nil}},
+		{"Dont filter", []*Request{
+			NewRequest("https://example.com/a"),
+			NewRequest("https://example.com/a", WithDontFilter(true)),
+			NewRequest("https://example.com/a", WithDontFilter(true)),
+		}, []error{nil, nil, nil}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := newTestRequestTable(t)
+			var added int64
+			for i, request := range tt.requests {
+				id, err := table.Add(request)
+				if !errors.Is(err, tt.wantErrs[i]) {
+					t.Fatalf("Add() #%d error = %v, expected %v", i, err, tt.wantErrs[i])
+				}
+				if err == nil {
+					added++
+					if id == 0 {
+						t.Errorf("Add() #%d id = 0, expected non-zero", i)
+					}
+				}
+			}
+			count, err := table.Count(RequestStatusPending)
+			if err != nil {
+				t.Fatalf("Count() error = %v", err)
+			}
+			if count != added {
+				t.Errorf("Count() = %d, expected %d", count, added)
+			}
+		})
+	}
+}
+
+func TestGormRequestTablePopOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		priorities []int
+		expected   []string
+	}{
+		{"Same priority", []int{0, 0, 0}, []string{"/0", "/1", "/2"}},
+		{"Higher priority first", []int{0, 2, 1}, []string{"/1", "/2", "/0"}},
+		{"Same priority keeps insertion order", []int{1, 2, 1, 2}, []string{"/1", "/3", "/0", "/2"}},
+		{"Negative priority last", []int{-1, 0, -2}, []string{"/1", "/0", "/2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := newTestRequestTable(t)
+			for i, priority := range tt.priorities {
+				request := NewRequest("https://example.com/"+strconv.Itoa(i), WithPriority(priority))
+				if _, err := table.Add(request); err != nil {
+					t.Fatalf("Add() error = %v", err)
+				}
+			}
+			for i, path := range tt.expected {
+				request, err := table.Pop()
+				if err != nil {
+					t.Fatalf("Pop() #%d error = %v", i, err)
+				}
+				if request.Url.Path != path {
+					t.Errorf("Pop() #%d = %s, expected %s", i, request.Url.Path, path)
+				}
+				index, _ := strconv.Atoi(path[1:])
+				if request.Priority != tt.priorities[index] {
+					t.Errorf("Pop() #%d priority = %d, expected %d", i, request.Priority, tt.priorities[index])
+				}
+			}
+			if _, err := table.Pop(); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Errorf("Pop() on empty table error = %v, expected %v", err, gorm.ErrRecordNotFound)
+			}
+		})
+	}
+}
+
+// newTestWorker 创建与table共用同一数据库和表的另一个worker
+func newTestWorker(table *GormRequestTable, workerId string) *GormRequestTable {
+	return &GormRequestTable{
+		db:            table.db,
+		table:         table.table,
+		Fingerprinter: table.Fingerprinter,
+		WorkerId:      workerId,
+		Lease:         table.Lease,
+		MaxRetries:    table.MaxRetries,
+	}
+}
+
+// getTestRow 读取表中指定id的记录
+func getTestRow(t *testing.T, table *GormRequestTable, id uint) RequestTable {
+	t.Helper()
+	var rt RequestTable
+	if err := table.db.Table(table.table).Where("id = ?", id).Take(&rt).Error; err != nil {
+		t.Fatalf("Take() error = %v", err)
+	}
+	return rt
+}
+
+func TestGormRequestTableConcurrentPop(t *testing.T) {
+	tests := []struct {
+		name       string
+		workers    int
+		goroutines int
+		requests   int
+	}{
+		{"Single worker", 1, 8, 50},
+		{"Two workers", 2, 4, 50},
+		{"More goroutines than requests", 4, 4, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := newTestRequestTable(t)
+			for i := 0; i < tt.requests; i++ {
+				if _, err := table.Add(NewRequest("https://example.com/" + strconv.Itoa(i))); err != nil {
+					t.Fatalf("Add() error = %v", err)
+				}
+			}
+
+			var mu sync.Mutex
+			claimed := make(map[uint]string)
+			var wg sync.WaitGroup
+			for w := 0; w < tt.workers; w++ {
+				worker := newTestWorker(table, "worker-"+strconv.Itoa(w))
+				for g := 0; g < tt.goroutines; g++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for {
+							request, err := worker.Pop()
+							if err != nil {
+								if !errors.Is(err, gorm.ErrRecordNotFound) {
+									t.Errorf("Pop() error = %v", err)
+								}
+								return
+							}
+							id, _ := worker.requestId(request)
+							mu.Lock()
+							if other, ok := claimed[id]; ok {
+								t.Errorf("request %d claimed by both %s and %s", id, other, worker.WorkerId)
+							}
+							claimed[id] = worker.WorkerId
+							mu.Unlock()
+						}
+					}()
+				}
+			}
+			wg.Wait()
+
+			if len(claimed) != tt.requests {
+				t.Errorf("claimed %d requests, expected %d", len(claimed), tt.requests)
+			}
+			for id, workerId := range claimed {
+				rt := getTestRow(t, table, id)
+				if rt.Status != RequestStatusRunning || rt.WorkerId != workerId || rt.LeaseUntil == nil {
+					t.Errorf("request %d status = %d, worker = %q, lease = %v, expected running by %q with lease", id, rt.Status, rt.WorkerId, rt.LeaseUntil, workerId)
+				}
+			}
+		})
+	}
+}
+
+func TestGormRequestTableReclaimExpired(t *testing.T) {
+	tests := []struct {
+		name          string
+		lease         time.Duration
+		nullLease     bool
+		retries       int
+		maxRetries    int
+		wantReclaimed int64
+		wantStatus    uint8
+		wantRetries   int
+	}{
+		{"Lease not expired", time.Hour, false, 0, 3, 0, RequestStatusRunning, 0},
+		{"Lease expired", -time.Second, false, 0, 3, 1, RequestStatusPending, 1},
+		{"Null lease", time.Hour, true, 0, 3, 1, RequestStatusPending, 1},
+		{"Below max retries", -time.Second, false, 2, 3, 1, RequestStatusPending, 3},
+		{"Max retries reached", -time.Second, false, 3, 3, 0, RequestStatusDropped, 3},
+		{"Max retries reached with null lease", time.Hour, true, 3, 3, 0, RequestStatusDropped, 3},
+		{"Max retries reached but lease not expired", time.Hour, false, 3, 3, 0, RequestStatusRunning, 3},
+		{"Unlimited retries", -time.Second, false, 10, 0, 1, RequestStatusPending, 11},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := newTestRequestTable(t)
+			table.Lease = tt.lease
+			id, err := table.Add(NewRequest("https://example.com/a"))
+			if err != nil {
+				t.Fatalf("Add() error = %v", err)
+			}
+			if _, err = table.Pop(); err != nil {
+				t.Fatalf("Pop() error = %v", err)
+			}
+			values := map[string]any{"retries": tt.retries}
+			if tt.nullLease {
+				values["lease_until"] = nil
+			}
+			if err = table.db.Table(table.table).Where("id = ?", id).Updates(values).Error; err != nil {
+				t.Fatalf("Updates() error = %v", err)
+			}
+
+			other := newTestWorker(table, "other")
+			other.MaxRetries = tt.maxRetries
+			reclaimed, err := other.ReclaimExpired()
+			if err != nil {
+				t.Fatalf("ReclaimExpired() error = %v", err)
+			}
+			if reclaimed != tt.wantReclaimed {
+				t.Errorf("ReclaimExpired() = %d, expected %d", reclaimed, tt.wantReclaimed)
+			}
+			rt := getTestRow(t, table, id)
+			if rt.Status != tt.wantStatus {
+				t.Errorf("status = %d, expected %d", rt.Status, tt.wantStatus)
+			}
+			if rt.Retries != tt.wantRetries {
+				t.Errorf("retries = %d, expected %d", rt.Retries, tt.wantRetries)
+			}
+			if tt.wantStatus == RequestStatusPending && (rt.WorkerId != "" || rt.LeaseUntil != nil) {
+				t.Errorf("worker = %q, lease = %v, expected both cleared", rt.WorkerId, rt.LeaseUntil)
+			}
+		})
+	}
+}
+
+func TestGormRequestTableRelease(t *testing.T) {
+	tests := []struct {
+		name         string
+		ownRunning   int
+		otherRunning int
+		pending      int
+		wantReleased int64
+	}{
+		{"Nothing running", 0, 0, 2, 0},
+		{"Own requests", 2, 0, 1, 2},
+		{"Other worker's requests kept", 2, 1, 1, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := newTestRequestTable(t)
+			table.WorkerId = "own"
+			other := newTestWorker(table, "other")
+			total := tt.ownRunning + tt.otherRunning + tt.pending
+			for i := 0; i < total; i++ {
+				if _, err := table.Add(NewRequest("https://example.com/" + strconv.Itoa(i))); err != nil {
+					t.Fatalf("Add() error = %v", err)
+				}
+			}
+			var own []uint
+			for i := 0; i < tt.ownRunning; i++ {
+				request, err := table.Pop()
+				if err != nil {
+					t.Fatalf("Pop() error = %v", err)
+				}
+				id, _ := table.requestId(request)
+				own = append(own, id)
+			}
+			for i := 0; i < tt.otherRunning; i++ {
+				if _, err := other.Pop(); err != nil {
+					t.Fatalf("Pop() error = %v", err)
+				}
+			}
+
+			released, err := table.Release()
+			if err != nil {
+				t.Fatalf("Release() error = %v", err)
+			}
+			if released != tt.wantReleased {
+				t.Errorf("Release() = %d, expected %d", released, tt.wantReleased)
+			}
+			for _, id := range own {
+				rt := getTestRow(t, table, id)
+				if rt.Status != RequestStatusPending || rt.WorkerId != "" || rt.LeaseUntil != nil || rt.Retries != 0 {
+					t.Errorf("request %d status = %d, worker = %q, lease = %v, retries = %d, expected pending without retry", id, rt.Status, rt.WorkerId, rt.LeaseUntil, rt.Retries)
+				}
+			}
+			if count, _ := table.Count(RequestStatusRunning); count != int64(tt.otherRunning) {
+				t.Errorf("Count(running) = %d, expected %d", count, tt.otherRunning)
+			}
+			if count, _ := table.Count(RequestStatusPending); count != int64(tt.ownRunning+tt.pending) {
+				t.Errorf("Count(pending) = %d, expected %d", count, tt.ownRunning+tt.pending)
+			}
+		})
+	}
+}
+
+func TestGormRequestTableDataRoundTrip(t *testing.T) {
+	ctx := container.NewSyncJsonMap()
+	container.Set(ctx, "page", "2")
+	headers := http.Header{}
+	headers.Set("X-Token", "abc")
+	headers.Add("Accept", "text/html")
+	headers.Add("Accept", "application/json")
+
+	tests := []struct {
+		name    string
+		request *Request
+	}{
+		{"Plain get", NewRequest("https://example.com/a")},
+		{"Post with body", NewRequest("https://example.com/a", WithMethod("POST"), WithBody(strings.NewReader("k=v&x=1")))},
+		{"Headers and cookies", NewRequest("https://example.com/a",
+			WithHeaders(headers),
+			WithCookies([]*http.Cookie{{Name: "session", Value: "s1"}, {Name: "lang", Value: "zh"}}),
+		)},
+		{"Callbacks and ctx", NewRequest("https://example.com/a",
+			WithCallback("parse_detail"),
+			WithErrback("handle_error"),
+			WithEncoding("gbk"),
+			WithCtx(ctx),
+		)},
+		{"Dont filter with priority", NewRequest("https://example.com/a", WithDontFilter(true), WithPriority(5))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := newTestRequestTable(t)
+			expected := tt.request.ToJsonMap().GetMap()
+			if _, err := table.Add(tt.request); err != nil {
+				t.Fatalf("Add() error = %v", err)
+			}
+			request, err := table.Pop()
+			if err != nil {
+				t.Fatalf("Pop() error = %v", err)
+			}
+			id, ok := table.requestId(request)
+			if !ok || id == 0 {
+				t.Errorf("requestId() = %d, %v, expected id in Ctx", id, ok)
+			}
+			result := request.ToJsonMap().GetMap()
+			// Pop在Ctx中记录id和表名，比较前去除
+			resultCtx, _ := result["ctx"].(map[string]any)
+			delete(resultCtx, "id")
+			delete(resultCtx, "table")
+			if len(resultCtx) == 0 {
+				resultCtx = nil
+			}
+			expectedCtx, _ := expected["ctx"].(map[string]any)
+			if len(expectedCtx) == 0 {
+				expectedCtx = nil
+			}
+			if !reflect.DeepEqual(resultCtx, expectedCtx) {
+				t.Errorf("Pop() ctx = %v, expected %v", resultCtx, expectedCtx)
+			}
+			delete(result, "ctx")
+			delete(expected, "ctx")
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("Pop() = %v, expected %v", result, expected)
+			}
+		})
+	}
+}
+
+func TestGormRequestTablePriorityColumn(t *testing.T) {
+	tests := []struct {
+		name     string
+		priority int
+		column   int
+	}{
+		{"Unchanged", 1, 1},
+		{"Raised in database", 1, 10},
+		{"Lowered in database", 1, -10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := newTestRequestTable(t)
+			id, err := table.Add(NewRequest("https://example.com/a", WithPriority(tt.priority)))
+			if err != nil {
+				t.Fatalf("Add() error = %v", err)
+			}
+			if err = table.db.Table(table.table).Where("id = ?", id).Update("priority", tt.column).Error; err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+			request, err := table.Pop()
+			if err != nil {
+				t.Fatalf("Pop() error = %v", err)
+			}
+			if request.Priority != tt.column {
+				t.Errorf("Pop() priority = %d, expected %d", request.Priority, tt.column)
+			}
+		})
+	}
+}
+
+func TestGormRequestTableLegacyRow(t *testing.T) {
+	tests := []struct {
+		name     string
+		row      RequestTable
+		wantUrl  string
+		wantBody string
+	}{
+		{"Get without body", RequestTable{Url: "https://example.com/a", Method: "GET", Fp: "fp-get"}, "https://example.com/a", ""},
+		{"Post with body", RequestTable{Url: "https://example.com/b", Method: "POST", Body: base64.StdEncoding.EncodeToString([]byte("k=v")), Fp: "fp-post"}, "https://example.com/b", "k=v"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := newTestRequestTable(t)
+			// 旧版本的记录没有Data字段
+			if err := table.db.Table(table.table).Create(&tt.row).Error; err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			request, err := table.Pop()
+			if err != nil {
+				t.Fatalf("Pop() error = %v", err)
+			}
+			if request.Url.String() != tt.wantUrl || request.Method != tt.row.Method {
+				t.Errorf("Pop() = %s %s, expected %s %s", request.Method, request.Url, tt.row.Method, tt.wantUrl)
+			}
+			if body := string(ReadRequestBody(request)); body != tt.wantBody {
+				t.Errorf("Pop() body = %q, expected %q", body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package xspider
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"mime"
@@ -15,6 +16,8 @@ import (
 type Response struct {
 	StatusCode int
 	Body       []byte
+	BodyFile   string // 设置了download_to_file时响应体保存的文件路径，此时Body为空
+	BodySize   int    // BodyFile的大小
 	Ctx        container.JsonMap
 	Request    *Request
 	Headers    *http.Header
@@ -37,6 +40,53 @@ func NewResponseWithRequest(response *http.Response, request *Request) (*Respons
 	}, nil
 }
 
+// BodyLen 返回响应体的大小，响应体保存在文件中时返回文件大小
+func (r *Response) BodyLen() int {
+	if r.BodyFile != "" {
+		return r.BodySize
+	}
+	return len(r.Body)
+}
+
+// BodyReader 返回读取响应体的Reader，使用完毕后需要关闭
+func (r *Response) BodyReader() (io.ReadCloser, error) {
+	if r.BodyFile != "" {
+		return os.Open(r.BodyFile)
+	}
+	return io.NopCloser(bytes.NewReader(r.Body)), nil
+}
+
+// sizeLimitReader 统计已读取的字节数，超过warnSize时调用一次onWarn，超过maxSize时返回*DownloadSizeError，
+// maxSize、warnSize小于等于0时不做限制
+type sizeLimitReader struct {
+	io.ReadCloser
+	url      string
+	size     int
+	maxSize  int
+	warnSize int
+	onWarn   func(size int)
+	warned   bool
+}
+
+func newSizeLimitReader(r io.ReadCloser, url string, maxSize int, warnSize int, onWarn func(size int)) *sizeLimitReader {
+	return &sizeLimitReader{ReadCloser: r, url: url, maxSize: maxSize, warnSize: warnSize, onWarn: onWarn}
+}
+
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.size += n
+	if r.warnSize > 0 && !r.warned && r.size > r.warnSize {
+		r.warned = true
+		if r.onWarn != nil {
+			r.onWarn(r.size)
+		}
+	}
+	if r.maxSize > 0 && r.size > r.maxSize {
+		return n, &DownloadSizeError{Url: r.url, Size: r.size, MaxSize: r.maxSize}
+	}
+	return n, err
+}
+
 // Save writes response body to disk
 func (r *Response) Save(fileName string) error {
 	dir := filepath.Dir(fileName)
@@ -50,7 +100,24 @@ func (r *Response) Save(fileName string) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(fileName, r.Body, 0777)
+	if r.BodyFile == "" {
+		return os.WriteFile(fileName, r.Body, 0777)
+	}
+
+	src, err := os.Open(r.BodyFile)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
 }
 
 // FileName returns the sanitized file name parsed from "Content-Disposition"
@@ -0,0 +1,226 @@
+// Package robotstxt 解析robots.txt，规则匹配方式参考RFC 9309
+package robotstxt
+
+import (
+	"bufio"
+	"bytes"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type rule struct {
+	allow   bool
+	pattern string
+}
+
+type group struct {
+	agents     []string
+	rules      []rule
+	crawlDelay time.Duration
+	hasDelay   bool
+}
+
+// RobotsData 解析后的robots.txt
+type RobotsData struct {
+	groups   []*group
+	Sitemaps []string
+}
+
+// AllowAll 允许访问所有路径的robots.txt
+func AllowAll() *RobotsData {
+	return &RobotsData{}
+}
+
+// DisallowAll 禁止访问所有路径的robots.txt
+func DisallowAll() *RobotsData {
+	return &RobotsData{groups: []*group{{agents: []string{"*"}, rules: []rule{{allow: false, pattern: "/"}}}}}
+}
+
+// FromStatusAndBytes 根据robots.txt的响应状态码和内容解析，
+// 按RFC 9309 2.3.1节，5xx响应视为禁止访问所有路径，其他非2xx响应视为允许访问所有路径
+func FromStatusAndBytes(statusCode int, body []byte) *RobotsData {
+	if statusCode >= 500 && statusCode < 600 {
+		return DisallowAll()
+	}
+	if statusCode < 200 || statusCode >= 300 {
+		return AllowAll()
+	}
+	return FromBytes(body)
+}
+
+// FromBytes 解析robots.txt内容，无法识别的行将被忽略
+func FromBytes(body []byte) *RobotsData {
+	r := &RobotsData{}
+	var current *group
+	// 连续的User-agent行属于同一组
+	inAgents := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				current = &group{}
+				r.groups = append(r.groups, current)
+				inAgents = true
+			}
+			if value == "*" {
+				current.agents = append(current.agents, value)
+			} else {
+				current.agents = append(current.agents, productToken(value))
+			}
+		case "allow", "disallow":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			// 空的Disallow表示不做限制
+			if value == "" {
+				continue
+			}
+			current.rules = append(current.rules, rule{allow: key == "allow", pattern: normalizePattern(value)})
+		case "crawl-delay":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			if delay, err := strconv.ParseFloat(value, 64); err == nil && delay >= 0 {
+				current.crawlDelay = time.Duration(delay * float64(time.Second))
+				current.hasDelay = true
+			}
+		case "sitemap":
+			r.Sitemaps = append(r.Sitemaps, value)
+		default:
+			inAgents = false
+		}
+	}
+	return r
+}
+
+// normalizePattern 统一规则中路径的百分号编码
+func normalizePattern(pattern string) string {
+	if !strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "*") {
+		pattern = "/" + pattern
+	}
+	if p, err := url.PathUnescape(pattern); err == nil {
+		pattern = p
+	}
+	return pattern
+}
+
+// productToken 提取User-Agent开头由字母、下划线和连字符组成的产品名并转为小写，
+// 如Mozilla/5.0 (compatible; xbot/1.0)的产品名为mozilla
+func productToken(agent string) string {
+	agent = strings.TrimSpace(agent)
+	end := strings.IndexFunc(agent, func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '-')
+	})
+	if end >= 0 {
+		agent = agent[:end]
+	}
+	return strings.ToLower(agent)
+}
+
+// findGroups 查找适用于agent的规则组，按RFC 9309只比较产品名且不区分大小写，
+// 产品名相同的多个组合并生效，没有时使用*组
+func (r *RobotsData) findGroups(agent string) []*group {
+	token := productToken(agent)
+	var matched, wildcard []*group
+	for _, g := range r.groups {
+		for _, a := range g.agents {
+			switch {
+			case a == "*":
+				wildcard = append(wildcard, g)
+			case a != "" && a == token:
+				matched = append(matched, g)
+			}
+		}
+	}
+	if len(matched) > 0 {
+		return matched
+	}
+	return wildcard
+}
+
+// TestAgent 判断agent是否允许访问path，path可以是完整的Url或者以/开头的路径
+func (r *RobotsData) TestAgent(path string, agent string) bool {
+	if u, err := url.Parse(path); err == nil && (u.Scheme != "" || u.Host != "") {
+		path = u.EscapedPath()
+		if u.RawQuery != "" {
+			path += "?" + u.RawQuery
+		}
+	}
+	if path == "" {
+		path = "/"
+	}
+	if p, err := url.PathUnescape(path); err == nil {
+		path = p
+	}
+	if path == "/robots.txt" {
+		return true
+	}
+
+	// 匹配长度最长的规则生效，长度相同时Allow优先
+	allowed, longest := true, -1
+	for _, g := range r.findGroups(agent) {
+		for _, rl := range g.rules {
+			if !match(rl.pattern, path) {
+				continue
+			}
+			if n := len(rl.pattern); n > longest || n == longest && rl.allow {
+				longest = n
+				allowed = rl.allow
+			}
+		}
+	}
+	return allowed
+}
+
+// CrawlDelay 返回适用于agent的Crawl-delay
+func (r *RobotsData) CrawlDelay(agent string) (time.Duration, bool) {
+	for _, g := range r.findGroups(agent) {
+		if g.hasDelay {
+			return g.crawlDelay, true
+		}
+	}
+	return 0, false
+}
+
+// match 判断path是否匹配pattern，*匹配任意字符，结尾的$表示匹配到路径末尾
+func match(pattern string, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = strings.TrimSuffix(pattern, "$")
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	for i := 1; i < len(parts); i++ {
+		part := parts[i]
+		// 结尾锚定时最后一段需要匹配路径的末尾
+		if anchored && i == len(parts)-1 {
+			return strings.HasSuffix(path[pos:], part)
+		}
+		idx := strings.Index(path[pos:], part)
+		if idx < 0 {
+			return false
+		}
+		pos += idx + len(part)
+	}
+	return !anchored || pos == len(path)
+}
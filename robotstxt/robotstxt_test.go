@@ -0,0 +1,113 @@
+package robotstxt
+
+import (
+	"testing"
+	"time"
+)
+
+const robots = `
+# comment
+User-agent: *
+Disallow: /private/
+Allow: /private/public
+Disallow: /*.pdf$
+Crawl-delay: 2
+
+User-agent: xbot
+User-agent: otherbot
+Disallow: /
+Allow: /open
+Crawl-delay: 0.5
+
+User-agent: emptybot
+Disallow:
+
+User-agent: bot/2.0
+Disallow: /bot-only
+
+Sitemap: https://example.com/sitemap.xml
+`
+
+func TestTestAgent(t *testing.T) {
+	data := FromBytes([]byte(robots))
+
+	tests := []struct {
+		name     string
+		path     string
+		agent    string
+		expected bool
+	}{
+		{"Wildcard allowed", "/index.html", "Mozilla", true},
+		{"Wildcard disallowed", "/private/a", "Mozilla", false},
+		{"Longer allow wins", "/private/public/a", "Mozilla", true},
+		{"Anchored pattern", "/files/a.pdf", "Mozilla", false},
+		{"Anchored pattern not at end", "/files/a.pdf?x=1", "Mozilla", true},
+		{"Named group", "/index.html", "xbot/1.0", false},
+		{"Named group allow", "/open/a", "xbot/1.0", true},
+		{"Product token only", "/index.html", "Mozilla/5.0 (compatible; xbot/1.0)", true},
+		{"Case insensitive", "/index.html", "XBot", false},
+		{"Short name does not match other agent", "/bot-only", "somebot/1.0", true},
+		{"Version in robots.txt ignored", "/bot-only", "bot", false},
+		{"Second agent of group", "/index.html", "otherbot", false},
+		{"Empty disallow", "/private/a", "emptybot", true},
+		{"Full url", "https://example.com/private/a", "Mozilla", false},
+		{"Robots.txt itself", "/robots.txt", "xbot", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := data.TestAgent(tt.path, tt.agent); got != tt.expected {
+				t.Errorf("TestAgent(%q, %q) = %v, expected %v", tt.path, tt.agent, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCrawlDelay(t *testing.T) {
+	data := FromBytes([]byte(robots))
+
+	tests := []struct {
+		name     string
+		agent    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"Wildcard delay", "Mozilla", 2 * time.Second, true},
+		{"Float delay", "xbot", 500 * time.Millisecond, true},
+		{"No delay", "emptybot", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := data.CrawlDelay(tt.agent)
+			if got != tt.expected || ok != tt.ok {
+				t.Errorf("CrawlDelay(%q) = %v, %v, expected %v, %v", tt.agent, got, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+func TestFromStatusAndBytes(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		expected   bool
+	}{
+		{"Success", 200, false},
+		{"Not found", 404, true},
+		{"Server error", 500, false},
+		{"Service unavailable", 503, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := FromStatusAndBytes(tt.statusCode, []byte("User-agent: *\nDisallow: /"))
+			if got := data.TestAgent("/a", "xbot"); got != tt.expected {
+				t.Errorf("TestAgent() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+	if data := FromBytes([]byte(robots)); len(data.Sitemaps) != 1 {
+		t.Errorf("Sitemaps = %v, expected 1 sitemap", data.Sitemaps)
+	}
+}
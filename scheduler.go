@@ -1,6 +1,13 @@
 package xspider
 
 import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
 	"github.com/emirpasic/gods/sets/hashset"
 	"github.com/xue0228/xspider/container"
 )
@@ -10,32 +17,97 @@ func init() {
 	RegisterSpiderModuler(&DupeFilterImpl{})
 }
 
+// DupeFilterImpl 设置了JOBDIR时将指纹保存在JOBDIR/requests.seen中，再次运行时恢复
 type DupeFilterImpl struct {
 	BaseSpiderModule
-	fingerprints *hashset.Set
+	fingerprinter RequestFingerprinter
+	fingerprints  *hashset.Set
+	file          *os.File
+	writer        *bufio.Writer
+	mu            sync.Mutex
 }
 
 func (d *DupeFilterImpl) FromSpider(spider *Spider) {
 	InitBaseSpiderModule(&d.BaseSpiderModule, spider, d.Name())
+	d.fingerprinter = spider.Fingerprinter
 	d.fingerprints = hashset.New()
+	if jobdir := container.GetWithDefault[string](spider.Settings, "JOBDIR", ""); jobdir != "" {
+		if err := d.open(filepath.Join(jobdir, "requests.seen")); err != nil {
+			d.Logger.Fatalw("打开指纹文件失败", "jobdir", jobdir, "error", err)
+		}
+		d.Logger.Infow("从磁盘恢复指纹", "jobdir", jobdir, "count", d.fingerprints.Size())
+	}
 	d.Logger.Info("模块初始化完成")
 }
 
+// open 读取已保存的指纹，之后新增的指纹追加到文件末尾
+func (d *DupeFilterImpl) open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fp := strings.TrimSpace(scanner.Text()); fp != "" {
+			d.fingerprints.Add(fp)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	d.file = f
+	d.writer = bufio.NewWriter(f)
+	return nil
+}
+
 func (d *DupeFilterImpl) Name() string {
 	return "DupeFilterImpl"
 }
 
 func (d *DupeFilterImpl) RequestSeen(request *Request) bool {
 	fp := d.RequestFingerprint(request)
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	if d.fingerprints.Contains(fp) {
 		return true
 	}
 	d.fingerprints.Add(fp)
+	if d.writer != nil {
+		if _, err := d.writer.WriteString(fp + "\n"); err != nil {
+			RequestLogger(d.Logger, request).Errorw("指纹写入磁盘失败", "error", err)
+		}
+	}
 	return false
 }
 
+func (d *DupeFilterImpl) Flush() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.writer == nil {
+		return nil
+	}
+	if err := d.writer.Flush(); err != nil {
+		return err
+	}
+	return d.file.Sync()
+}
+
+func (d *DupeFilterImpl) Close(spider *Spider) {
+	if err := d.Flush(); err != nil {
+		d.Logger.Errorw("指纹写入磁盘失败", "error", err)
+	}
+	if d.file != nil {
+		_ = d.file.Close()
+	}
+	d.BaseSpiderModule.Close(spider)
+}
+
 func (d *DupeFilterImpl) RequestFingerprint(request *Request) string {
-	return request.Fingerprint(nil, false)
+	return d.fingerprinter.Fingerprint(request)
 }
 
 func (d *DupeFilterImpl) Log(request *Request) {
@@ -63,6 +135,10 @@ func (s *SchedulerImpl) FromSpider(spider *Spider) {
 
 	//pqStr := spider.Settings.GetStringWithDefault("PRIORITY_QUEUE_STRUCT", "LIFOPriorityQueue")
 	pqStr := container.GetWithDefault[string](spider.Settings, "PRIORITY_QUEUE_STRUCT", "LIFOPriorityQueue")
+	// 设置了JOBDIR时使用磁盘队列，以便中断后继续运行
+	if container.GetWithDefault[string](spider.Settings, "JOBDIR", "") != "" {
+		pqStr = container.GetWithDefault[string](spider.Settings, "DISK_PRIORITY_QUEUE_STRUCT", "LIFODiskPriorityQueue")
+	}
 	s.pq = GetAndAssertComponent[PriorityQueuer](pqStr)
 	s.pq.FromSpider(spider)
 
@@ -101,6 +177,25 @@ func (s *SchedulerImpl) NextRequest() *Request {
 	}
 }
 
+func (s *SchedulerImpl) PeekRequest() *Request {
+	request := s.pq.Peek()
+	if request == nil {
+		return nil
+	}
+	return request.(*Request)
+}
+
+func (s *SchedulerImpl) Flush() error {
+	var errs []error
+	if f, ok := s.df.(Flusher); ok {
+		errs = append(errs, f.Flush())
+	}
+	if f, ok := s.pq.(Flusher); ok {
+		errs = append(errs, f.Flush())
+	}
+	return errors.Join(errs...)
+}
+
 func (s *SchedulerImpl) Close(spider *Spider) {
 	s.df.Close(spider)
 	s.pq.Close(spider)
@@ -1,5 +1,7 @@
 package xspider
 
+import "time"
+
 type Signal struct {
 	typ    SignalType
 	sender Sender
@@ -36,6 +38,7 @@ const (
 	SenderProcessResponse      Sender = "process_response"
 	SenderProcessError         Sender = "process_error"
 	SenderRequestErrback       Sender = "request_errback"
+	SenderRequestSlot          Sender = "request_slot"
 )
 
 const (
@@ -85,6 +88,17 @@ const (
 	StItemError SignalType = "item_error"
 	// StItemScraped Item被成功处理
 	StItemScraped SignalType = "item_scraped"
+
+	// StRobotsTxtCrawlDelay robots.txt中设置了Crawl-delay
+	StRobotsTxtCrawlDelay SignalType = "robotstxt_crawl_delay"
+	// StRequestSlotBackoff 服务器要求降低请求频率，暂停Request所在的slot
+	StRequestSlotBackoff SignalType = "request_slot_backoff"
+	// StCircuitOpened slot连续下载失败，熔断器打开
+	StCircuitOpened SignalType = "circuit_opened"
+	// StCircuitHalfOpened 熔断器冷却结束，允许一个探测Request
+	StCircuitHalfOpened SignalType = "circuit_half_opened"
+	// StCircuitClosed 探测Request下载成功，熔断器关闭
+	StCircuitClosed SignalType = "circuit_closed"
 )
 
 //type ResultsSignal struct {
@@ -306,3 +320,23 @@ func NewSpiderClosedSignal(sender Sender, reason string, spider *Spider) *Signal
 func NewRequestErrbackSignal(sender Sender, request *Request, response *Response, err error, spider *Spider) *Signal {
 	return NewSignal(StRequestErrback, sender, request, response, err, spider)
 }
+
+func NewRobotsTxtCrawlDelaySignal(sender Sender, request *Request, delay time.Duration, spider *Spider) *Signal {
+	return NewSignal(StRobotsTxtCrawlDelay, sender, request, delay, spider)
+}
+
+func NewRequestSlotBackoffSignal(sender Sender, request *Request, delay time.Duration, spider *Spider) *Signal {
+	return NewSignal(StRequestSlotBackoff, sender, request, delay, spider)
+}
+
+func NewCircuitOpenedSignal(sender Sender, key string, spider *Spider) *Signal {
+	return NewSignal(StCircuitOpened, sender, key, spider)
+}
+
+func NewCircuitHalfOpenedSignal(sender Sender, key string, spider *Spider) *Signal {
+	return NewSignal(StCircuitHalfOpened, sender, key, spider)
+}
+
+func NewCircuitClosedSignal(sender Sender, key string, spider *Spider) *Signal {
+	return NewSignal(StCircuitClosed, sender, key, spider)
+}
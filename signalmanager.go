@@ -280,9 +280,9 @@ func (sm *SignalManagerImpl) run() {
 		case signal := <-sm.signalChan:
 			// 收到信号，进行分发
 			//fmt.Println(signal)
+			sm.wg.Add(1)
+			sm.add(nil)
 			go func() {
-				sm.wg.Add(1)
-				sm.add(nil)
 				defer func() {
 					sm.wg.Done()
 					sm.done(nil)
@@ -315,8 +315,8 @@ func (sm *SignalManagerImpl) Start() {
 	}
 	sm.mu.Unlock()
 
+	sm.wg.Add(1)
 	go func() {
-		sm.wg.Add(1)
 		defer sm.wg.Done()
 		sm.run()
 	}()
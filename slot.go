@@ -1,7 +1,12 @@
 package xspider
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"math/rand/v2"
+	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -44,7 +49,7 @@ func (rs *ResponseSlotImpl) Add(response *Response) {
 	if response == nil {
 		return
 	}
-	rs.activeSize += Max(len(response.Body), rs.minResponseSize)
+	rs.activeSize += rs.responseSize(response)
 }
 
 func (rs *ResponseSlotImpl) Done(response *Response) {
@@ -54,7 +59,12 @@ func (rs *ResponseSlotImpl) Done(response *Response) {
 	if response == nil {
 		return
 	}
-	rs.activeSize -= Max(len(response.Body), rs.minResponseSize)
+	rs.activeSize -= rs.responseSize(response)
+}
+
+// responseSize Response占用的内存大小，响应体保存在文件中时不计入BodySize
+func (rs *ResponseSlotImpl) responseSize(response *Response) int {
+	return Max(len(response.Body), rs.minResponseSize)
 }
 
 func (rs *ResponseSlotImpl) IsFree() bool {
@@ -126,28 +136,76 @@ func (is *ItemSlotImpl) IsEmpty() bool {
 	return is.items.Empty() && is.active <= 0
 }
 
+// 熔断器状态
+const (
+	circuitClosed   uint8 = iota // 正常下载
+	circuitOpen                  // 暂停下载
+	circuitHalfOpen              // 只允许一个探测Request
+)
+
 type requestSlot struct {
 	concurrency    int
 	maxQueueSize   int
 	delay          time.Duration
 	randomizeDelay bool
-	requests       *llq.Queue
-	lastSeen       int64
-	lastDelay      int64
-	active         int
-	mu             sync.RWMutex
+	// rate大于0时使用令牌桶限速，每秒生成rate个令牌，最多保存burst个
+	rate       float64
+	burst      int
+	tokens     float64
+	lastRefill int64
+	// pausedUntil 暂停到该时间后才能取出Request
+	pausedUntil int64
+	// 熔断器状态、连续失败次数、打开状态的结束时间及半开状态下是否有正在下载的探测Request
+	circuit   uint8
+	failures  int
+	openUntil int64
+	probing   bool
+	requests  *llq.Queue
+	// reserved 等待加入的Request占用的排队位置
+	reserved  int
+	lastSeen  int64
+	lastDelay int64
+	active    int
+	mu        sync.RWMutex
+}
+
+func newRequestSlot(config RequestSlotConfig, maxQueueSize int) *requestSlot {
+	s := &requestSlot{
+		maxQueueSize: maxQueueSize,
+		requests:     llq.New(),
+		lastSeen:     0,
+		active:       0,
+	}
+	s.configure(config)
+	s.tokens = float64(s.burst)
+	return s
+}
+
+// configure 修改子slot的配置，令牌桶模式下delay只用于robots.txt中的Crawl-delay
+func (ds *requestSlot) configure(config RequestSlotConfig) {
+	ds.concurrency = config.Concurrency
+	ds.delay = config.Delay
+	ds.randomizeDelay = config.RandomizeDelay
+	ds.rate = config.Rate
+	ds.burst = max(config.Burst, 1)
+	if ds.rate > 0 {
+		ds.delay = 0
+	}
+	ds.tokens = min(ds.tokens, float64(ds.burst))
+	ds.lastDelay = 0
 }
 
-func newRequestSlot(concurrency int, delay time.Duration, randomizeDelay bool, maxQueueSize int) *requestSlot {
-	return &requestSlot{
-		concurrency:    concurrency,
-		delay:          delay,
-		randomizeDelay: randomizeDelay,
-		maxQueueSize:   maxQueueSize,
-		requests:       llq.New(),
-		lastSeen:       0,
-		active:         0,
+// takeToken 按经过的时间补充令牌，有令牌时消耗一个并返回true
+func (ds *requestSlot) takeToken(now int64) bool {
+	if ds.lastRefill > 0 {
+		ds.tokens = min(ds.tokens+float64(now-ds.lastRefill)/float64(time.Second)*ds.rate, float64(ds.burst))
+	}
+	ds.lastRefill = now
+	if ds.tokens < 1 {
+		return false
 	}
+	ds.tokens--
+	return true
 }
 
 func (ds *requestSlot) push(request *Request) {
@@ -198,13 +256,13 @@ func (ds *requestSlot) isFree() bool {
 func (ds *requestSlot) isEmpty() bool {
 	ds.mu.RLock()
 	defer ds.mu.RUnlock()
-	return ds.requests.Empty() && ds.active <= 0
+	return ds.requests.Empty() && ds.active <= 0 && ds.reserved <= 0
 }
 
 func (ds *requestSlot) isQueueFull() bool {
 	ds.mu.RLock()
 	defer ds.mu.RUnlock()
-	return ds.requests.Size() >= ds.maxQueueSize
+	return ds.requests.Size()+ds.reserved >= ds.maxQueueSize
 }
 
 func (ds *requestSlot) queueLen() int {
@@ -219,27 +277,93 @@ func (ds *requestSlot) activeLen() int {
 	return ds.active
 }
 
-type requestSlotConfig struct {
-	concurrency    int
-	delay          time.Duration
-	randomizeDelay bool
+// RequestSlotConfig 子slot的并发数及限速方式。
+// Rate大于0时使用令牌桶限速，每秒最多下载Rate个Request，允许连续下载Burst个，此时忽略Delay；
+// 否则每次下载间隔Delay
+type RequestSlotConfig struct {
+	Concurrency    int
+	Delay          time.Duration
+	RandomizeDelay bool
+	Rate           float64
+	Burst          int
+}
+
+const (
+	// SlotKeyModeDomain 按公共后缀列表计算的注册域名（eTLD+1）划分，如a.example.co.uk和b.example.co.uk共用example.co.uk
+	SlotKeyModeDomain = "domain"
+	// SlotKeyModeHost 按完整主机名划分
+	SlotKeyModeHost = "host"
+	// SlotKeyModeIp 按主机名解析得到的IP划分，解析在后台进行，完成前使用主机名
+	SlotKeyModeIp = "ip"
+)
+
+// readSlotKeyMode 读取SLOT_KEY_MODE设置项，默认为SlotKeyModeDomain
+func readSlotKeyMode(settings container.JsonMap) (string, error) {
+	mode := strings.ToLower(container.GetWithDefault[string](settings, "SLOT_KEY_MODE", SlotKeyModeDomain))
+	switch mode {
+	case SlotKeyModeDomain, SlotKeyModeHost, SlotKeyModeIp:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported slot key mode: %s", mode)
+	}
+}
+
+// resolvedHostTtl SLOT_KEY_MODE为ip时主机名解析结果的缓存时长
+const resolvedHostTtl = 5 * time.Minute
+
+// resolvedHost 主机名的解析结果，pending为true时正在后台解析
+type resolvedHost struct {
+	ip      string
+	expires int64
+	pending bool
 }
 
+// RequestSlotImpl 按SlotKey分别限制并发数及下载间隔的RequestSloter。
+// CIRCUIT_BREAKER_ENABLED为true时每个子slot带有熔断器：连续CIRCUIT_BREAKER_THRESHOLD次连接错误或5xx响应后打开，
+// CIRCUIT_BREAKER_COOLDOWN秒内不再下载排队的Request，CIRCUIT_BREAKER_DROP为true时直接以ErrCircuitOpen丢弃；
+// 冷却结束后半开，下载一个探测Request，成功则关闭，失败则重新打开
 type RequestSlotImpl struct {
 	BaseSpiderModule
+	spider                     *Spider
+	breakerEnabled             bool
+	breakerThreshold           int
+	breakerCooldown            time.Duration
+	breakerDrop                bool
+	slotKeyMode                string
 	slots                      map[string]*requestSlot
 	concurrentRequests         int
 	maxQueueSize               int
 	concurrentRequestPerDomain int
-	downloadDelay              int
+	downloadDelay              time.Duration
+	downloadRate               float64
+	downloadBurst              int
 	randomizeDelay             bool
-	requestSlots               map[string]requestSlotConfig
+	startDelay                 time.Duration
+	requestSlots               map[string]RequestSlotConfig
+	crawlDelays                map[string]time.Duration
+	keys                       map[*Request]string // 已加入的Request所属的子slot，保证ip模式下解析完成前后Push与Finish使用同一个子slot
+	reserved                   map[*Request]string
+	dnsOverrides               map[string]string
+	resolved                   map[string]*resolvedHost
+	resolveMu                  sync.Mutex
 	mu                         sync.RWMutex
 }
 
 func (rs *RequestSlotImpl) FromSpider(spider *Spider) {
 	InitBaseSpiderModule(&rs.BaseSpiderModule, spider, rs.Name())
 	rs.slots = make(map[string]*requestSlot)
+	mode, err := readSlotKeyMode(spider.Settings)
+	if err != nil {
+		rs.Logger.Fatalw("SLOT_KEY_MODE设置错误", "error", err)
+	}
+	rs.slotKeyMode = mode
+	rs.keys = make(map[*Request]string)
+	rs.reserved = make(map[*Request]string)
+	rs.resolved = make(map[string]*resolvedHost)
+	rs.dnsOverrides = make(map[string]string)
+	for host, ip := range container.GetWithDefault[map[string]string](spider.Settings, "DNS_OVERRIDES", map[string]string{}) {
+		rs.dnsOverrides[strings.ToLower(host)] = ip
+	}
 
 	//rs.concurrentRequests = spider.Settings.GetIntWithDefault("CONCURRENT_REQUESTS", 16)
 	//rs.maxQueueSize = spider.Settings.GetIntWithDefault("MAX_REQUEST_QUEUE_SIZE_PER_DOMAIN", rs.concurrentRequests)
@@ -252,10 +376,17 @@ func (rs *RequestSlotImpl) FromSpider(spider *Spider) {
 	//rs.downloadDelay = spider.Settings.GetIntWithDefault("DOWNLOAD_DELAY", 1)
 	//rs.randomizeDelay = spider.Settings.GetBoolWithDefault("RANDOMIZE_DOWNLOAD_DELAY", true)
 	rs.concurrentRequestPerDomain = container.GetWithDefault[int](spider.Settings, "CONCURRENT_REQUESTS_PER_DOMAIN", 1)
-	rs.downloadDelay = container.GetWithDefault[int](spider.Settings, "DOWNLOAD_DELAY", 1)
+	rs.downloadDelay = SecondsToDuration(container.GetWithDefault[float64](spider.Settings, "DOWNLOAD_DELAY", 1))
+	rs.downloadRate = container.GetWithDefault[float64](spider.Settings, "DOWNLOAD_RATE", 0)
+	rs.downloadBurst = container.GetWithDefault[int](spider.Settings, "DOWNLOAD_BURST", 1)
 	rs.randomizeDelay = container.GetWithDefault[bool](spider.Settings, "RANDOMIZE_DOWNLOAD_DELAY", true)
+	// 启用AutoThrottle时，未单独设置的子slot以AUTOTHROTTLE_START_DELAY作为初始间隔
+	rs.startDelay = rs.downloadDelay
+	if container.GetWithDefault[bool](spider.Settings, "AUTOTHROTTLE_ENABLED", false) {
+		rs.startDelay = max(rs.downloadDelay, SecondsToDuration(container.GetWithDefault[float64](spider.Settings, "AUTOTHROTTLE_START_DELAY", 5)))
+	}
 
-	rs.requestSlots = make(map[string]requestSlotConfig)
+	rs.requestSlots = make(map[string]RequestSlotConfig)
 	requestSlots := container.GetWithDefault(spider.Settings, "REQUEST_SLOTS", map[string]map[string]any{})
 	//requestSlotsAny := spider.Settings.GetWithDefault("REQUEST_SLOTS", map[string]map[string]any{})
 	//requestSlots := make(map[string]map[string]any)
@@ -280,24 +411,35 @@ func (rs *RequestSlotImpl) FromSpider(spider *Spider) {
 	for domain, config := range requestSlots {
 		var (
 			concurrency    = rs.concurrentRequestPerDomain // 默认值
-			delay          = time.Duration(rs.downloadDelay) * time.Second
+			delay          = rs.downloadDelay
 			randomizeDelay = rs.randomizeDelay
+			rate           = rs.downloadRate
+			burst          = rs.downloadBurst
 		)
 
 		// 解析 concurrency
 		if val, ok := config["concurrency"]; ok {
-			if c, ok := val.(int); ok {
+			if c, err := container.ConvertToJsonSupportType[int](val); err == nil {
 				concurrency = c
 			}
 		}
 
-		// 解析 delay（单位：秒）
+		// 解析 delay（单位：秒，可以是小数）
 		if val, ok := config["delay"]; ok {
-			switch v := val.(type) {
-			case int:
-				delay = time.Duration(v) * time.Second
-			case float64:
-				delay = time.Duration(v) * time.Second
+			if v, err := container.ConvertToJsonSupportType[float64](val); err == nil {
+				delay = SecondsToDuration(v)
+			}
+		}
+
+		// 解析 rate（每秒请求数）及 burst
+		if val, ok := config["rate"]; ok {
+			if v, err := container.ConvertToJsonSupportType[float64](val); err == nil {
+				rate = v
+			}
+		}
+		if val, ok := config["burst"]; ok {
+			if v, err := container.ConvertToJsonSupportType[int](val); err == nil {
+				burst = v
 			}
 		}
 
@@ -308,12 +450,29 @@ func (rs *RequestSlotImpl) FromSpider(spider *Spider) {
 			}
 		}
 
-		rs.requestSlots[domain] = requestSlotConfig{
-			concurrency:    concurrency,
-			delay:          delay,
-			randomizeDelay: randomizeDelay,
+		rs.requestSlots[domain] = RequestSlotConfig{
+			Concurrency:    concurrency,
+			Delay:          delay,
+			RandomizeDelay: randomizeDelay,
+			Rate:           rate,
+			Burst:          burst,
 		}
 	}
+
+	rs.crawlDelays = make(map[string]time.Duration)
+	spider.Signal.Connect(rs.robotsTxtCrawlDelay, StRobotsTxtCrawlDelay, 500)
+	spider.Signal.Connect(rs.requestSlotBackoff, StRequestSlotBackoff, 500)
+
+	rs.spider = spider
+	rs.breakerEnabled = container.GetWithDefault[bool](spider.Settings, "CIRCUIT_BREAKER_ENABLED", false)
+	rs.breakerThreshold = container.GetWithDefault[int](spider.Settings, "CIRCUIT_BREAKER_THRESHOLD", 5)
+	rs.breakerCooldown = SecondsToDuration(container.GetWithDefault[float64](spider.Settings, "CIRCUIT_BREAKER_COOLDOWN", 60))
+	rs.breakerDrop = container.GetWithDefault[bool](spider.Settings, "CIRCUIT_BREAKER_DROP", false)
+	if rs.breakerEnabled {
+		// 只统计实际下载的结果，不包括缓存等由中间件返回的Response
+		spider.Signal.Connect(rs.responseDownloaded, StResponseLeftDownloader, 500, SenderDownloader)
+		spider.Signal.Connect(rs.downloadFailed, StDownloaderError, 500, SenderDownloader)
+	}
 	rs.Logger.Info("模块初始化完成")
 }
 
@@ -321,30 +480,263 @@ func (rs *RequestSlotImpl) Name() string {
 	return "RequestSlotImpl"
 }
 
+// SlotKey 按SLOT_KEY_MODE获取Request所属的子slot，Ctx中设置了download_slot或domain时优先使用
+func (rs *RequestSlotImpl) SlotKey(request *Request) string {
+	if rs.slotKeyMode == SlotKeyModeDomain {
+		return request.SlotKey()
+	}
+	for _, key := range []string{"download_slot", "domain"} {
+		if s, err := container.Get[string](request.Ctx, key); err == nil && s != "" {
+			return s
+		}
+	}
+	if rs.slotKeyMode == SlotKeyModeHost {
+		return request.Host()
+	}
+	return rs.resolveHost(request)
+}
+
+// resolveHost 获取Request主机名对应的IP，优先使用DNS_OVERRIDES；
+// 缓存中没有或已过期时在后台解析，不阻塞调用方，解析完成前使用缓存中的旧结果或主机名
+func (rs *RequestSlotImpl) resolveHost(request *Request) string {
+	host := request.Host()
+	if host == "unknown" || net.ParseIP(host) != nil {
+		return host
+	}
+	port := request.Url.Port()
+	if port == "" {
+		port = "80"
+		if request.Url.Scheme == "https" {
+			port = "443"
+		}
+	}
+	if ip, ok := rs.dnsOverrides[net.JoinHostPort(host, port)]; ok {
+		return ip
+	}
+	if ip, ok := rs.dnsOverrides[host]; ok {
+		return ip
+	}
+
+	rs.resolveMu.Lock()
+	defer rs.resolveMu.Unlock()
+	entry, ok := rs.resolved[host]
+	if !ok {
+		entry = &resolvedHost{}
+		rs.resolved[host] = entry
+	}
+	if !entry.pending && entry.expires < time.Now().UnixNano() {
+		entry.pending = true
+		go rs.lookup(host, entry)
+	}
+	if entry.ip == "" {
+		return host
+	}
+	return entry.ip
+}
+
+// lookup 解析主机名，失败时保留旧结果，缓存时长内不再重试
+func (rs *RequestSlotImpl) lookup(host string, entry *resolvedHost) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+
+	rs.resolveMu.Lock()
+	defer rs.resolveMu.Unlock()
+	if err != nil || len(ips) == 0 {
+		rs.Logger.Debugw("解析主机名失败，使用主机名作为子slot", "host", host, "error", err)
+	} else {
+		entry.ip = ips[0].IP.String()
+	}
+	entry.expires = time.Now().Add(resolvedHostTtl).UnixNano()
+	entry.pending = false
+}
+
+// requestKey 获取已加入的Request所属的子slot，需在持有rs.mu时调用
+func (rs *RequestSlotImpl) requestKey(request *Request) string {
+	if key, ok := rs.keys[request]; ok {
+		return key
+	}
+	return rs.SlotKey(request)
+}
+
+// Reserve 为暂时不能加入的Request占用子slot的排队位置，直到调用Unreserve
+func (rs *RequestSlotImpl) Reserve(request *Request) {
+	key := rs.SlotKey(request)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if _, ok := rs.reserved[request]; ok {
+		return
+	}
+	rs.reserved[request] = key
+	slot := rs.slot(key)
+	slot.mu.Lock()
+	slot.reserved++
+	slot.mu.Unlock()
+}
+
+func (rs *RequestSlotImpl) Unreserve(request *Request) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	key, ok := rs.reserved[request]
+	if !ok {
+		return
+	}
+	delete(rs.reserved, request)
+	if slot, ok := rs.slots[key]; ok {
+		slot.mu.Lock()
+		slot.reserved--
+		slot.mu.Unlock()
+	}
+}
+
 func (rs *RequestSlotImpl) Push(request *Request) {
+	key := rs.SlotKey(request)
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
 
-	domain := request.Domain()
+	rs.keys[request] = key
+	rs.slot(key).push(request)
+}
+
+// slot 获取子slot，不存在时创建
+func (rs *RequestSlotImpl) slot(domain string) *requestSlot {
 	if slot, ok := rs.slots[domain]; ok {
-		slot.push(request)
+		return slot
+	}
+	var s *requestSlot
+	if config, ok := rs.requestSlots[domain]; ok {
+		maxQueueSize := config.Concurrency
+		if maxQueueSize <= 0 {
+			maxQueueSize = rs.maxQueueSize
+		}
+		s = newRequestSlot(config, maxQueueSize)
 	} else {
-		var s *requestSlot
-		if config, ok := rs.requestSlots[domain]; ok {
-			maxQueueSize := config.concurrency
-			if maxQueueSize <= 0 {
-				maxQueueSize = rs.maxQueueSize
+		s = newRequestSlot(rs.defaultConfig(), rs.maxQueueSize)
+	}
+	if delay, ok := rs.crawlDelays[domain]; ok && delay > s.delay {
+		s.delay = delay
+	}
+	rs.slots[domain] = s
+	return s
+}
+
+// requestSlotBackoff 暂停Request所在的子slot，已经暂停时取较晚的结束时间
+func (rs *RequestSlotImpl) requestSlotBackoff(request *Request, delay time.Duration, spider *Spider) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	domain := rs.requestKey(request)
+	slot := rs.slot(domain)
+	until := time.Now().Add(delay).UnixNano()
+	if until > slot.pausedUntil {
+		slot.pausedUntil = until
+	}
+	rs.Stats.IncValue("request_slot/paused", 1, 0)
+	rs.Logger.Infow("暂停下载", "domain", domain, "delay", delay.String())
+}
+
+// robotsTxtCrawlDelay 使用robots.txt中的Crawl-delay作为对应slot的最小下载间隔
+func (rs *RequestSlotImpl) robotsTxtCrawlDelay(request *Request, delay time.Duration, spider *Spider) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	domain := rs.requestKey(request)
+	rs.crawlDelays[domain] = delay
+	if slot, ok := rs.slots[domain]; ok && delay > slot.delay {
+		slot.delay = delay
+	}
+	rs.Logger.Infow("根据robots.txt调整下载间隔", "domain", domain, "crawl_delay", delay.String())
+}
+
+func (rs *RequestSlotImpl) responseDownloaded(request *Request, response *Response, spider *Spider) {
+	rs.recordResult(request, response.StatusCode < 500)
+}
+
+func (rs *RequestSlotImpl) downloadFailed(request *Request, err error, spider *Spider) {
+	// 超过大小限制说明站点可以正常访问，视为成功，半开状态下的探测Request也由此结束
+	rs.recordResult(request, errors.Is(err, ErrDownloadMaxSize))
+}
+
+// recordResult 根据下载结果更新熔断器状态
+func (rs *RequestSlotImpl) recordResult(request *Request, success bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	key := rs.requestKey(request)
+	slot, ok := rs.slots[key]
+	if !ok {
+		return
+	}
+	if success {
+		slot.failures = 0
+		if slot.circuit == circuitHalfOpen {
+			slot.circuit = circuitClosed
+			slot.probing = false
+			rs.Stats.IncValue("circuit_breaker/closed", 1, 0)
+			rs.Logger.Infow("熔断器关闭", "slot", key)
+			rs.spider.Signal.Emit(NewCircuitClosedSignal(SenderRequestSlot, key, rs.spider))
+		}
+		return
+	}
+
+	slot.failures++
+	if slot.circuit == circuitHalfOpen || (slot.circuit == circuitClosed && slot.failures >= rs.breakerThreshold) {
+		slot.circuit = circuitOpen
+		slot.probing = false
+		slot.openUntil = time.Now().Add(rs.breakerCooldown).UnixNano()
+		rs.Stats.IncValue("circuit_breaker/opened", 1, 0)
+		rs.Logger.Warnw("熔断器打开", "slot", key, "failures", slot.failures, "cooldown", rs.breakerCooldown.String())
+		rs.spider.Signal.Emit(NewCircuitOpenedSignal(SenderRequestSlot, key, rs.spider))
+	}
+}
+
+// checkCircuit 判断子slot的熔断器是否允许下载，打开状态下根据设置取出排队的Request用于丢弃，冷却结束后转为半开
+func (rs *RequestSlotImpl) checkCircuit(key string, slot *requestSlot, now int64) (bool, []*Request) {
+	switch slot.circuit {
+	case circuitOpen:
+		if now < slot.openUntil {
+			if rs.breakerDrop {
+				return false, rs.takeQueued(slot)
 			}
-			s = newRequestSlot(config.concurrency, config.delay, config.randomizeDelay, maxQueueSize)
-		} else {
-			s = newRequestSlot(
-				rs.concurrentRequests,
-				time.Duration(rs.downloadDelay)*time.Second,
-				rs.randomizeDelay,
-				rs.maxQueueSize)
+			return false, nil
+		}
+		slot.circuit = circuitHalfOpen
+		slot.probing = false
+		rs.Stats.IncValue("circuit_breaker/half_opened", 1, 0)
+		rs.Logger.Infow("熔断器半开", "slot", key)
+		rs.spider.Signal.Emit(NewCircuitHalfOpenedSignal(SenderRequestSlot, key, rs.spider))
+		fallthrough
+	case circuitHalfOpen:
+		return !slot.probing, nil
+	}
+	return true, nil
+}
+
+// takeQueued 取出子slot中排队的所有Request，调用时需持有rs.mu
+func (rs *RequestSlotImpl) takeQueued(slot *requestSlot) []*Request {
+	var requests []*Request
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	for {
+		value, ok := slot.requests.Dequeue()
+		if !ok {
+			return requests
 		}
-		s.push(request)
-		rs.slots[domain] = s
+		request := value.(*Request)
+		delete(rs.keys, request)
+		requests = append(requests, request)
+	}
+}
+
+// dropRequests 以ErrCircuitOpen丢弃Request，调用时不能持有rs.mu，避免信号的接收者再次访问slot时阻塞
+func (rs *RequestSlotImpl) dropRequests(requests []*Request) {
+	for _, request := range requests {
+		rs.Stats.IncValue("circuit_breaker/dropped", 1, 0)
+		RequestLogger(rs.Logger, request).Infow("熔断器打开，丢弃Request")
+		rs.spider.Signal.Emit(NewRequestDroppedSignal(SenderRequestSlot, request, ErrCircuitOpen, rs.spider))
+		rs.spider.Signal.Emit(NewRequestErrbackSignal(SenderRequestSlot, request, nil, ErrCircuitOpen, rs.spider))
 	}
 }
 
@@ -352,16 +744,106 @@ func (rs *RequestSlotImpl) Finish(request *Request) {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
 
-	domain := request.Domain()
+	domain := rs.requestKey(request)
+	delete(rs.keys, request)
 	rs.slots[domain].finish()
 }
 
+// defaultConfig 未单独设置的子slot使用的配置
+func (rs *RequestSlotImpl) defaultConfig() RequestSlotConfig {
+	return RequestSlotConfig{
+		Concurrency:    rs.concurrentRequests,
+		Delay:          rs.startDelay,
+		RandomizeDelay: rs.randomizeDelay,
+		Rate:           rs.downloadRate,
+		Burst:          rs.downloadBurst,
+	}
+}
+
+func (rs *RequestSlotImpl) SlotConfig(key string) (RequestSlotConfig, bool) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	if slot, ok := rs.slots[key]; ok {
+		slot.mu.RLock()
+		defer slot.mu.RUnlock()
+		return RequestSlotConfig{
+			Concurrency:    slot.concurrency,
+			Delay:          slot.delay,
+			RandomizeDelay: slot.randomizeDelay,
+			Rate:           slot.rate,
+			Burst:          slot.burst,
+		}, true
+	}
+	if config, ok := rs.requestSlots[key]; ok {
+		return config, false
+	}
+	return rs.defaultConfig(), false
+}
+
+func (rs *RequestSlotImpl) SetSlotConfig(key string, config RequestSlotConfig) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	slot, ok := rs.slots[key]
+	if !ok {
+		rs.requestSlots[key] = config
+		return
+	}
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	slot.configure(config)
+	// 不小于robots.txt中的Crawl-delay
+	if delay, ok := rs.crawlDelays[key]; ok && delay > slot.delay {
+		slot.delay = delay
+	}
+}
+
+func (rs *RequestSlotImpl) SlotLen(key string) int {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	slot, ok := rs.slots[key]
+	if !ok {
+		return 0
+	}
+	return slot.queueLen() + slot.activeLen()
+}
+
+func (rs *RequestSlotImpl) IsSlotFree(key string) bool {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	if rs.concurrentRequests > 0 {
+		active := 0
+		for _, slot := range rs.slots {
+			active += slot.activeLen()
+		}
+		if active >= rs.concurrentRequests {
+			return false
+		}
+	}
+	slot, ok := rs.slots[key]
+	if !ok {
+		return true
+	}
+	return !slot.isQueueFull() && slot.isFree()
+}
+
 func (rs *RequestSlotImpl) Pop() Requests {
+	// 遍历子slot的快照，处理期间其他goroutine仍可以创建或删除子slot
+	rs.mu.RLock()
+	slots := make(map[string]*requestSlot, len(rs.slots))
+	for key, slot := range rs.slots {
+		slots[key] = slot
+	}
+	rs.mu.RUnlock()
+
 	res := make(chan *Request)
 	go func() {
 		defer close(res)
-		for _, slot := range rs.slots {
-			request := rs.processQueue(slot)
+		for key, slot := range slots {
+			request := rs.processQueue(key, slot)
 			if request != nil {
 				res <- request
 			}
@@ -408,19 +890,33 @@ func (rs *RequestSlotImpl) Clear(age time.Duration) {
 	defer rs.mu.Unlock()
 
 	for domain, slot := range rs.slots {
-		if slot.activeLen() <= 0 &&
-			slot.queueLen() <= 0 &&
+		if slot.isEmpty() &&
+			slot.pausedUntil < time.Now().UnixNano() &&
+			slot.circuit == circuitClosed &&
 			slot.lastSeen+int64(slot.delay) < time.Now().UnixNano()-int64(age) {
 			delete(rs.slots, domain)
 		}
 	}
 }
 
-func (rs *RequestSlotImpl) processQueue(slot *requestSlot) *Request {
+func (rs *RequestSlotImpl) processQueue(key string, slot *requestSlot) *Request {
+	request, dropped := rs.popQueue(key, slot)
+	rs.dropRequests(dropped)
+	return request
+}
+
+// popQueue 取出子slot中可以下载的Request，以及熔断器打开时需要丢弃的Request
+func (rs *RequestSlotImpl) popQueue(key string, slot *requestSlot) (*Request, []*Request) {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
 
 	now := time.Now().UnixNano()
+	if now < slot.pausedUntil {
+		return nil, nil
+	}
+	if ok, dropped := rs.checkCircuit(key, slot, now); !ok {
+		return nil, dropped
+	}
 	if slot.lastDelay == 0 {
 		slot.lastDelay = slot.downloadDelay()
 		//fmt.Println(slot.lastDelay)
@@ -428,11 +924,21 @@ func (rs *RequestSlotImpl) processQueue(slot *requestSlot) *Request {
 	if slot.delay > 0 {
 		penalty := slot.lastDelay + slot.lastSeen - now
 		if penalty > 0 {
-			return nil
+			return nil, nil
+		}
+	}
+	if !slot.isFree() {
+		return nil, nil
+	}
+	if slot.rate > 0 {
+		// 令牌桶模式，队列为空时不消耗令牌
+		if slot.queueLen() == 0 || !slot.takeToken(now) {
+			return nil, nil
 		}
 	}
-	if slot.isFree() {
-		return slot.pop()
+	request := slot.pop()
+	if request != nil && slot.circuit == circuitHalfOpen {
+		slot.probing = true
 	}
-	return nil
+	return request, nil
 }
@@ -13,9 +13,12 @@ type Spider struct {
 	Logger           *zap.SugaredLogger
 	Stats            Statser
 	Settings         container.JsonMap
+	Fingerprinter    RequestFingerprinter // 各模块共用的Request指纹计算方式
+	State            container.JsonMap    // 爬虫运行状态，设置了JOBDIR时由SpiderStateExtension保存，再次运行时恢复
 	Starts           Results
 	DefaultParseFunc string
 
+	requestSlot       RequestSloter
 	scheduler         Scheduler
 	downloader        Downloader
 	spiderManager     SpiderMiddlewareManager
@@ -57,6 +60,7 @@ func (s *Spider) init() {
 		s.Logger = zap.NewNop().Sugar()
 	}
 	s.Logger = s.Logger.With("bot_name", s.Name)
+	s.State = container.NewSyncJsonMap()
 
 	// 初始化统计收集器
 	//statserName := s.Settings.GetStringWithDefault("STATS_STRUCT", "StatserImpl")
@@ -70,6 +74,16 @@ func (s *Spider) init() {
 	s.Signal = GetAndAssertComponent[SignalManager](signalManagerName)
 	s.Signal.FromSpider(s)
 
+	// 初始化指纹计算器
+	fingerprinterName := container.GetWithDefault[string](s.Settings, "REQUEST_FINGERPRINTER_STRUCT", "RequestFingerprinterImpl")
+	s.Fingerprinter = GetAndAssertComponent[RequestFingerprinter](fingerprinterName)
+	s.Fingerprinter.FromSpider(s)
+
+	// 初始化下载slot，调度器和引擎共用
+	requestSlotName := container.GetWithDefault[string](s.Settings, "REQUEST_SLOT_STRUCT", "RequestSlotImpl")
+	s.requestSlot = GetAndAssertComponent[RequestSloter](requestSlotName)
+	s.requestSlot.FromSpider(s)
+
 	// 初始化调度器
 	//schedulerName := s.Settings.GetStringWithDefault("SCHEDULER_STRUCT", "SchedulerImpl")
 	schedulerName := container.GetWithDefault[string](s.Settings, "SCHEDULER_STRUCT", "SchedulerImpl")
@@ -133,6 +147,8 @@ func (s *Spider) Close() {
 	s.spiderManager.Close(s)
 	s.downloader.Close(s)
 	s.scheduler.Close(s)
+	s.requestSlot.Close(s)
+	s.Fingerprinter.Close(s)
 	s.Signal.Close(s)
 	s.Stats.Close(s)
 }
@@ -3,6 +3,7 @@ package xspider
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/emirpasic/gods/sets/hashset"
 	"github.com/xue0228/xspider/container"
@@ -103,14 +104,7 @@ func (sm *AllowedDomainSpiderMiddleware) ProcessSpiderOutput(response *Response,
 					sm.Stats.IncValue("allowed_domain/domains", 1, 0)
 				}
 				if len(sm.allowedDomains) > 0 {
-					flag := false
-					for _, domain := range sm.allowedDomains {
-						if d == domain {
-							flag = true
-							break
-						}
-					}
-					if !flag {
+					if !IsDomainAllowed(req.Host(), sm.allowedDomains) {
 						RequestLogger(sm.Logger, req).Infow("请求的域名不在允许的域名列表中", "allowed_domains", sm.allowedDomains)
 						sm.Stats.IncValue("allowed_domain/filtered", 1, 0)
 						continue
@@ -122,6 +116,29 @@ func (sm *AllowedDomainSpiderMiddleware) ProcessSpiderOutput(response *Response,
 	})
 }
 
+// IsDomainAllowed 判断主机名是否属于允许的域名列表中的域名或其子域名，列表为空时不做限制
+func IsDomainAllowed(host string, allowedDomains []string) bool {
+	if len(allowedDomains) == 0 {
+		return true
+	}
+	for _, d := range allowedDomains {
+		if IsSubdomain(host, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSubdomain 判断主机名是否为域名本身或其子域名，不区分大小写
+func IsSubdomain(host, domain string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" {
+		return false
+	}
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
 type DepthSpiderMiddleware struct {
 	BaseSpiderMiddleware
 	maxDepth     int
@@ -0,0 +1,218 @@
+package xspider
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/xue0228/xspider/container"
+)
+
+func init() {
+	RegisterSpiderModuler(&RequestTableScheduler{})
+}
+
+// RequestTableScheduler 使用数据库中的RequestTable作为待爬取队列的调度器，中断后再次运行时继续处理未完成的Request。
+// 数据库由REQUEST_TABLE_DIALECT（sqlite、mysql、postgres）、REQUEST_TABLE_DSN和REQUEST_TABLE_NAME设置，
+// 表中Fp字段唯一，已加入过的Request不会重复加入。
+// Request的状态根据信号自动更新：Response到达爬虫时为处理完成，Request被丢弃或出错时为已丢弃。
+// 多个进程可以共用同一个表，每个进程由REQUEST_TABLE_WORKER_ID区分，取出的Request租约时长为REQUEST_TABLE_LEASE秒，
+// 运行期间定期延长自己的租约并回收其他worker过期的租约，关闭时归还未完成的Request
+type RequestTableScheduler struct {
+	BaseSpiderModule
+	table         *GormRequestTable
+	df            DupeFilter
+	filterEnabled bool
+	interval      time.Duration
+	quitChan      chan struct{}
+	wg            sync.WaitGroup
+}
+
+func (s *RequestTableScheduler) Name() string {
+	return "RequestTableScheduler"
+}
+
+func (s *RequestTableScheduler) FromSpider(spider *Spider) {
+	InitBaseSpiderModule(&s.BaseSpiderModule, spider, s.Name())
+
+	dialect := container.GetWithDefault[string](spider.Settings, "REQUEST_TABLE_DIALECT", "sqlite")
+	name := container.GetWithDefault[string](spider.Settings, "REQUEST_TABLE_NAME", "requests")
+	table, err := NewGormRequestTableFromSettings(spider.Settings)
+	if err != nil {
+		s.Logger.Fatalw("打开RequestTable失败", "dialect", dialect, "table", name, "error", err)
+	}
+	// 使用Spider的指纹计算器，REQUEST_FINGERPRINTER_STRUCT为自定义实现时同样适用
+	table.Fingerprinter = spider.Fingerprinter
+	s.table = table
+
+	// 只有一个worker时，可以直接重新处理上次异常退出时正在处理的Request，无需等待租约过期
+	if container.GetWithDefault[bool](spider.Settings, "REQUEST_TABLE_RESET_RUNNING", false) {
+		if err = s.table.SetStatus(RequestStatusRunning, RequestStatusPending); err != nil {
+			s.Logger.Errorw("重置RequestTable状态失败", "error", err)
+		}
+	}
+	s.reclaim()
+	if count, err := s.table.Count(RequestStatusPending); err == nil && count > 0 {
+		s.Logger.Infow("从RequestTable恢复Request", "table", name, "count", count)
+	}
+
+	s.filterEnabled = container.GetWithDefault[bool](spider.Settings, "DUPE_FILTER_ENABLED", true)
+	dfStr := container.GetWithDefault[string](spider.Settings, "DUPE_FILTER_STRUCT", "DupeFilterImpl")
+	s.df = GetAndAssertComponent[DupeFilter](dfStr)
+	s.df.FromSpider(spider)
+
+	spider.Signal.Connect(s.responseReachedSpider, StResponseReachedSpider, 400)
+	spider.Signal.Connect(s.requestDropped, StRequestDropped, 400)
+	spider.Signal.Connect(s.requestFailed, StRequestErrback, 400)
+	spider.Signal.Connect(s.requestFailed, StErrorUnhandled, 400)
+
+	// 租约时长的1/3作为心跳间隔
+	s.interval = max(table.Lease/3, time.Second)
+	s.quitChan = make(chan struct{})
+	s.wg.Add(1)
+	go s.heartbeat()
+
+	s.Logger.Infow("模块初始化完成", "dialect", dialect, "table", name, "worker", table.WorkerId)
+}
+
+// heartbeat 定期延长自己的租约并回收过期的租约
+func (s *RequestTableScheduler) heartbeat() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-time.After(s.interval):
+			if _, err := s.table.Renew(); err != nil {
+				s.Logger.Errorw("延长RequestTable租约失败", "error", err)
+			}
+			s.reclaim()
+		case <-s.quitChan:
+			return
+		}
+	}
+}
+
+func (s *RequestTableScheduler) reclaim() {
+	count, err := s.table.ReclaimExpired()
+	if err != nil {
+		s.Logger.Errorw("回收RequestTable过期租约失败", "error", err)
+		return
+	}
+	if count > 0 {
+		s.Stats.IncValue("request_table/reclaimed", int(count), 0)
+		s.Logger.Infow("回收RequestTable过期租约", "count", count)
+	}
+}
+
+func (s *RequestTableScheduler) responseReachedSpider(response *Response, spider *Spider) {
+	s.setStatus(response.Request, RequestStatusDone)
+}
+
+func (s *RequestTableScheduler) requestDropped(request *Request, err error, spider *Spider) {
+	s.setStatus(request, RequestStatusDropped)
+}
+
+func (s *RequestTableScheduler) requestFailed(request *Request, response *Response, err error, spider *Spider) {
+	s.setStatus(request, RequestStatusDropped)
+}
+
+// setStatus 更新从表中取出的Request的状态，其他Request不做处理
+func (s *RequestTableScheduler) setStatus(request *Request, status uint8) {
+	if _, ok := s.table.requestId(request); !ok {
+		return
+	}
+	var err error
+	if status == RequestStatusDone {
+		_, err = s.table.Done(request)
+		s.Stats.IncValue("request_table/done", 1, 0)
+	} else {
+		_, err = s.table.Drop(request)
+		s.Stats.IncValue("request_table/dropped", 1, 0)
+	}
+	if errors.Is(err, ErrRequestTableLeaseLost) {
+		s.Stats.IncValue("request_table/lease_lost", 1, 0)
+		RequestLogger(s.Logger, request).Warnw("Request租约已过期并被其他worker取出", "status", status)
+	} else if err != nil {
+		RequestLogger(s.Logger, request).Errorw("更新RequestTable状态失败", "status", status, "error", err)
+	}
+}
+
+func (s *RequestTableScheduler) HasPendingRequests() bool {
+	ok, err := s.table.HasPending()
+	if err != nil {
+		s.Logger.Errorw("查询RequestTable失败", "error", err)
+	}
+	return ok
+}
+
+func (s *RequestTableScheduler) EnqueueRequest(request *Request) bool {
+	// 重试、重定向等由已取出的Request生成的新Request将作为新的记录加入，
+	// 新记录写入成功（或被判定为重复）后原记录才视为处理完成，写入失败时原记录保持未完成，关闭时随其他未完成的Request一起归还
+	var original *Request
+	if _, ok := s.table.requestId(request); ok {
+		original = request.Copy()
+		_, _ = request.Ctx.Delete("id")
+		_, _ = request.Ctx.Delete("table")
+	}
+
+	if s.filterEnabled && !request.DontFilter && s.df.RequestSeen(request) {
+		s.df.Log(request)
+		s.finishOriginal(original)
+		return false
+	}
+	if _, err := s.table.Add(request); err != nil {
+		if errors.Is(err, ErrRequestTableDuplicate) {
+			s.df.Log(request)
+			s.finishOriginal(original)
+		} else {
+			RequestLogger(s.Logger, request).Errorw("Request写入RequestTable失败", "error", err)
+		}
+		return false
+	}
+	s.finishOriginal(original)
+	s.Stats.IncValue("scheduler/enqueued", 1, 0)
+	RequestLogger(s.Logger, request).Debug("Request入队")
+	return true
+}
+
+// finishOriginal 将生成新Request的原记录标记为完成
+func (s *RequestTableScheduler) finishOriginal(original *Request) {
+	if original != nil {
+		s.setStatus(original, RequestStatusDone)
+	}
+}
+
+func (s *RequestTableScheduler) NextRequest() *Request {
+	request, err := s.table.Pop()
+	if err != nil {
+		if !isNotFound(err) {
+			s.Logger.Errorw("从RequestTable取出Request失败", "error", err)
+		}
+		return nil
+	}
+	s.Stats.IncValue("scheduler/dequeued", 1, 0)
+	RequestLogger(s.Logger, request).Debug("Request出队")
+	return request
+}
+
+func (s *RequestTableScheduler) Flush() error {
+	if f, ok := s.df.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (s *RequestTableScheduler) Close(spider *Spider) {
+	close(s.quitChan)
+	s.wg.Wait()
+	if count, err := s.table.Release(); err != nil {
+		s.Logger.Errorw("归还RequestTable未完成的Request失败", "error", err)
+	} else if count > 0 {
+		s.Logger.Infow("归还RequestTable未完成的Request", "count", count)
+	}
+	s.df.Close(spider)
+	if err := s.table.Close(); err != nil {
+		s.Logger.Errorw("关闭RequestTable失败", "error", err)
+	}
+	s.BaseSpiderModule.Close(spider)
+}
@@ -12,6 +12,7 @@ import (
 	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/kennygrant/sanitize"
 	"github.com/xue0228/xspider/encoder"
@@ -61,6 +62,11 @@ func Max[T cmp.Ordered](a, b T) T {
 	return b
 }
 
+// SecondsToDuration 将秒数（可以是小数）转换为time.Duration
+func SecondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
 // StringToLevel 将字符串转换为 zapcore.Level
 func StringToLevel(levelStr string) (zapcore.Level, error) {
 	var level zapcore.Level
@@ -106,7 +112,7 @@ func ReadRequestBody(request *Request) []byte {
 }
 
 func GetResponseSize(response *Response) int {
-	return len(response.Body) + GetHeaderSize(*response.Headers) + GetStatusSize(response.StatusCode) + 4
+	return response.BodyLen() + GetHeaderSize(*response.Headers) + GetStatusSize(response.StatusCode) + 4
 }
 
 // CookiesToString 将 []*http.Cookie 转换为分号分隔的字符串